@@ -0,0 +1,250 @@
+package appinsightstrace
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	// Default target of telemetry items per second, per telemetry type
+	DefaultMaxItemsPerSecond = 5
+	// Default interval at which the sampling percentage is re-evaluated
+	DefaultSamplingEvaluationInterval = 15 * time.Second
+	// Default minimum time between a change of the sampling percentage and a
+	// decrease
+	DefaultSamplingDecreaseTimeout = 2 * time.Minute
+	// Default minimum time between a change of the sampling percentage and an
+	// increase
+	DefaultSamplingIncreaseTimeout = 15 * time.Minute
+	// Default weight of the latest rate in the moving average
+	DefaultMovingAverageRatio = 0.25
+	// Default minimum sampling percentage
+	DefaultMinSamplingPercentage = 0.1
+)
+
+// Options for the adaptive sampler, modeled after the adaptive sampling of
+// the .NET SDK
+//
+// MaxItemsPerSecond: the target rate of kept items per telemetry type,
+//
+//	defaults to DefaultMaxItemsPerSecond
+//
+// InitialPercentage: the sampling percentage used until the first
+//
+//	evaluation, defaults to MaxPercentage
+//
+// MinPercentage: the lower bound of the sampling percentage, defaults to
+//
+//	DefaultMinSamplingPercentage
+//
+// MaxPercentage: the upper bound of the sampling percentage, defaults to 100
+// EvaluationInterval: the interval at which the rate is measured and the
+//
+//	sampling percentage re-evaluated, defaults to
+//	DefaultSamplingEvaluationInterval
+//
+// DecreaseTimeout: the minimum time after a change before the percentage can
+//
+//	be decreased, defaults to DefaultSamplingDecreaseTimeout
+//
+// IncreaseTimeout: the minimum time after a change before the percentage can
+//
+//	be increased, defaults to DefaultSamplingIncreaseTimeout
+//
+// MovingAverageRatio: the weight (0, 1] of the latest measured rate in the
+//
+//	moving average, defaults to DefaultMovingAverageRatio
+//
+// Clock: the clock used to measure the rate, defaults to the system clock,
+//
+//	a fakeclock can be provided for deterministic tests
+type AdaptiveSamplerOptions struct {
+	MaxItemsPerSecond  float64
+	InitialPercentage  float64
+	MinPercentage      float64
+	MaxPercentage      float64
+	EvaluationInterval time.Duration
+	DecreaseTimeout    time.Duration
+	IncreaseTimeout    time.Duration
+	MovingAverageRatio float64
+	Clock              clock.Clock
+}
+
+// ISampler that adjusts a sampling percentage per telemetry type so the kept
+// items approach a target rate. The rate of every type is measured (before
+// sampling) each evaluation interval and smoothed with a moving average, the
+// percentage then moves toward target / rate within the bounds, rounded so
+// every kept item represents a whole number of items. Items are kept from the
+// SamplingScore of their operation id, so within a trace the items of the
+// types with the lowest percentage are kept only if the rest of the trace is
+// also kept
+type AdaptiveSampler struct {
+	maxItemsPerSecond  float64
+	initialPercentage  float64
+	minPercentage      float64
+	maxPercentage      float64
+	evaluationInterval time.Duration
+	decreaseTimeout    time.Duration
+	increaseTimeout    time.Duration
+	movingAverageRatio float64
+	clock              clock.Clock
+
+	mtx   sync.Mutex
+	types map[string]*adaptiveState
+}
+
+type adaptiveState struct {
+	percentage float64
+	// items seen since the last evaluation
+	count      int
+	average    float64
+	measured   bool
+	lastEval   time.Time
+	lastChange time.Time
+}
+
+var _ ISampler = (*AdaptiveSampler)(nil)
+
+// Constructs an AdaptiveSampler, check AdaptiveSamplerOptions for the defaults
+func NewAdaptiveSampler(optn *AdaptiveSamplerOptions) *AdaptiveSampler {
+	if optn == nil {
+		optn = &AdaptiveSamplerOptions{}
+	}
+	s := &AdaptiveSampler{
+		maxItemsPerSecond:  optn.MaxItemsPerSecond,
+		minPercentage:      optn.MinPercentage,
+		maxPercentage:      optn.MaxPercentage,
+		evaluationInterval: optn.EvaluationInterval,
+		decreaseTimeout:    optn.DecreaseTimeout,
+		increaseTimeout:    optn.IncreaseTimeout,
+		movingAverageRatio: optn.MovingAverageRatio,
+		clock:              optn.Clock,
+		types:              map[string]*adaptiveState{},
+	}
+	if s.maxItemsPerSecond <= 0 {
+		s.maxItemsPerSecond = DefaultMaxItemsPerSecond
+	}
+	if s.maxPercentage <= 0 || s.maxPercentage > 100 {
+		s.maxPercentage = 100
+	}
+	if s.minPercentage <= 0 {
+		s.minPercentage = DefaultMinSamplingPercentage
+	}
+	if s.minPercentage > s.maxPercentage {
+		s.minPercentage = s.maxPercentage
+	}
+	s.initialPercentage = s.clamp(optn.InitialPercentage)
+	if optn.InitialPercentage <= 0 {
+		s.initialPercentage = s.maxPercentage
+	}
+	if s.evaluationInterval <= 0 {
+		s.evaluationInterval = DefaultSamplingEvaluationInterval
+	}
+	if s.decreaseTimeout <= 0 {
+		s.decreaseTimeout = DefaultSamplingDecreaseTimeout
+	}
+	if s.increaseTimeout <= 0 {
+		s.increaseTimeout = DefaultSamplingIncreaseTimeout
+	}
+	if s.movingAverageRatio <= 0 || s.movingAverageRatio > 1 {
+		s.movingAverageRatio = DefaultMovingAverageRatio
+	}
+	if s.clock == nil {
+		s.clock = clock.NewClock()
+	}
+	return s
+}
+
+func (s *AdaptiveSampler) Sample(item ITelemetry) (bool, float64) {
+	now := s.clock.Now()
+	s.mtx.Lock()
+	state := s.state(telemetryType(item), now)
+	state.count++
+	s.evaluate(state, now)
+	percentage := state.percentage
+	s.mtx.Unlock()
+
+	return isSampledIn(item, percentage), percentage
+}
+
+// Gets the current sampling percentage of every telemetry type seen so far,
+// keyed by the type name (Request, Dependency, Trace etc.)
+func (s *AdaptiveSampler) Percentages() map[string]float64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	percentages := make(map[string]float64, len(s.types))
+	for name, state := range s.types {
+		percentages[name] = state.percentage
+	}
+	return percentages
+}
+
+// Gets the current sampling percentage of the telemetry type (Request,
+// Dependency, Trace etc.), the initial percentage is returned for types not
+// seen so far
+func (s *AdaptiveSampler) Percentage(telemetryType string) float64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if state, ok := s.types[telemetryType]; ok {
+		return state.percentage
+	}
+	return s.initialPercentage
+}
+
+func (s *AdaptiveSampler) state(name string, now time.Time) *adaptiveState {
+	state, ok := s.types[name]
+	if !ok {
+		state = &adaptiveState{
+			percentage: s.initialPercentage,
+			lastEval:   now,
+		}
+		s.types[name] = state
+	}
+	return state
+}
+
+// Measures the rate and moves the percentage once the evaluation interval
+// has elapsed
+func (s *AdaptiveSampler) evaluate(state *adaptiveState, now time.Time) {
+	elapsed := now.Sub(state.lastEval)
+	if elapsed < s.evaluationInterval {
+		return
+	}
+	rate := float64(state.count) / elapsed.Seconds()
+	if state.measured {
+		state.average = s.movingAverageRatio*rate +
+			(1-s.movingAverageRatio)*state.average
+	} else {
+		state.average = rate
+		state.measured = true
+	}
+	state.count = 0
+	state.lastEval = now
+
+	suggested := s.maxPercentage
+	if state.average > 0 {
+		suggested = s.clamp(s.maxItemsPerSecond / state.average * 100)
+	}
+	sinceChange := now.Sub(state.lastChange)
+	if (suggested < state.percentage && sinceChange >= s.decreaseTimeout) ||
+		(suggested > state.percentage && sinceChange >= s.increaseTimeout) {
+		state.percentage = suggested
+		state.lastChange = now
+	}
+}
+
+// Clamps the percentage to the bounds, rounded down so that 100 / percentage
+// (the number of items a kept item represents) is a whole number
+func (s *AdaptiveSampler) clamp(percentage float64) float64 {
+	if percentage >= s.maxPercentage {
+		return s.maxPercentage
+	}
+	rounded := 100 / math.Ceil(100/percentage)
+	if rounded < s.minPercentage {
+		return s.minPercentage
+	}
+	return rounded
+}
@@ -0,0 +1,173 @@
+package appinsightstrace_test
+
+import (
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+// Emits perSecond items of every telemetry type each second for the number
+// of seconds, advancing the fake clock
+func sampleFor(
+	s *ait.AdaptiveSampler,
+	clk *fakeclock.FakeClock,
+	perSecond int,
+	seconds int,
+	items ...func() ait.ITelemetry,
+) {
+	if len(items) == 0 {
+		items = append(items, newSampledRequest)
+	}
+	for i := 0; i < seconds; i++ {
+		for j := 0; j < perSecond; j++ {
+			for _, item := range items {
+				s.Sample(item())
+			}
+		}
+		clk.Increment(time.Second)
+	}
+}
+
+func newSampledRequest() ait.ITelemetry {
+	return &ait.RequestTelemetry{
+		TelemetryBase: ait.TelemetryBase{OperationId: ait.NewTraceId()},
+	}
+}
+
+func newSampledDependency() ait.ITelemetry {
+	return &ait.DependencyTelemetry{
+		TelemetryBase: ait.TelemetryBase{OperationId: ait.NewTraceId()},
+	}
+}
+
+func TestAdaptiveSamplerConverges(t *testing.T) {
+	clk := fakeclock.NewFakeClock(time.Unix(0, 0))
+	s := ait.NewAdaptiveSampler(&ait.AdaptiveSamplerOptions{
+		MaxItemsPerSecond: 10,
+		Clock:             clk,
+	})
+
+	if p := s.Percentage("Request"); p != 100 {
+		t.Fatalf("initial percentage %v, expected 100", p)
+	}
+	// 1000 items per second for a target of 10 converges to 1%
+	sampleFor(s, clk, 1000, 5*60)
+	p := s.Percentage("Request")
+	if p > 1 || p < 0.9 {
+		t.Fatalf("percentage %v, expected about 1", p)
+	}
+	if 100/p != float64(int(100/p)) {
+		t.Errorf("percentage %v does not represent a whole number of items", p)
+	}
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if keep, percentage := s.Sample(newSampledRequest()); keep {
+			kept++
+			if percentage != p {
+				t.Fatalf("kept with percentage %v, expected %v", percentage, p)
+			}
+		}
+	}
+	if kept < 50 || kept > 150 {
+		t.Errorf("kept %d of 10000 items at %v%%", kept, p)
+	}
+}
+
+func TestAdaptiveSamplerBounds(t *testing.T) {
+	t.Run("min", func(t *testing.T) {
+		clk := fakeclock.NewFakeClock(time.Unix(0, 0))
+		s := ait.NewAdaptiveSampler(&ait.AdaptiveSamplerOptions{
+			MaxItemsPerSecond: 1,
+			MinPercentage:     5,
+			Clock:             clk,
+		})
+		sampleFor(s, clk, 1000, 60)
+		if p := s.Percentage("Request"); p != 5 {
+			t.Errorf("percentage %v, expected the minimum 5", p)
+		}
+	})
+
+	t.Run("max", func(t *testing.T) {
+		clk := fakeclock.NewFakeClock(time.Unix(0, 0))
+		s := ait.NewAdaptiveSampler(&ait.AdaptiveSamplerOptions{
+			MaxItemsPerSecond: 10,
+			MaxPercentage:     50,
+			Clock:             clk,
+		})
+		if p := s.Percentage("Request"); p != 50 {
+			t.Fatalf("initial percentage %v, expected the maximum 50", p)
+		}
+		sampleFor(s, clk, 1, 60*60)
+		if p := s.Percentage("Request"); p != 50 {
+			t.Errorf("percentage %v, expected the maximum 50", p)
+		}
+	})
+}
+
+func TestAdaptiveSamplerTimeouts(t *testing.T) {
+	clk := fakeclock.NewFakeClock(time.Unix(0, 0))
+	s := ait.NewAdaptiveSampler(&ait.AdaptiveSamplerOptions{
+		MaxItemsPerSecond:  10,
+		EvaluationInterval: time.Second,
+		DecreaseTimeout:    10 * time.Second,
+		IncreaseTimeout:    60 * time.Second,
+		MovingAverageRatio: 1,
+		Clock:              clk,
+	})
+
+	// the first change is not delayed
+	sampleFor(s, clk, 100, 3)
+	initial := s.Percentage("Request")
+	if initial >= 100 {
+		t.Fatalf("percentage %v, expected a decrease", initial)
+	}
+
+	// decreases wait for the decrease timeout after the last change
+	sampleFor(s, clk, 1000, 5)
+	if p := s.Percentage("Request"); p != initial {
+		t.Fatalf("percentage %v changed before the decrease timeout", p)
+	}
+	sampleFor(s, clk, 1000, 10)
+	decreased := s.Percentage("Request")
+	if decreased >= initial {
+		t.Fatalf("percentage %v, expected a decrease below %v", decreased, initial)
+	}
+
+	// increases wait for the (longer) increase timeout
+	sampleFor(s, clk, 5, 30)
+	if p := s.Percentage("Request"); p != decreased {
+		t.Fatalf("percentage %v changed before the increase timeout", p)
+	}
+	sampleFor(s, clk, 5, 40)
+	if p := s.Percentage("Request"); p != 100 {
+		t.Fatalf("percentage %v, expected an increase to 100", p)
+	}
+}
+
+func TestAdaptiveSamplerPercentages(t *testing.T) {
+	clk := fakeclock.NewFakeClock(time.Unix(0, 0))
+	s := ait.NewAdaptiveSampler(&ait.AdaptiveSamplerOptions{
+		MaxItemsPerSecond: 10,
+		Clock:             clk,
+	})
+	if len(s.Percentages()) != 0 {
+		t.Fatalf("unexpected percentages %v", s.Percentages())
+	}
+
+	// the types are sampled independently
+	sampleFor(s, clk, 5, 60, newSampledDependency)
+	sampleFor(s, clk, 1000, 60, newSampledRequest)
+	percentages := s.Percentages()
+	if len(percentages) != 2 {
+		t.Fatalf("expected 2 types, got %v", percentages)
+	}
+	if percentages["Dependency"] != 100 {
+		t.Errorf("dependency percentage %v, expected 100", percentages["Dependency"])
+	}
+	if p := percentages["Request"]; p >= 100 || p != s.Percentage("Request") {
+		t.Errorf("request percentage %v, expected a decrease", p)
+	}
+}
@@ -3,8 +3,8 @@ package appinsightstrace
 import (
 	"context"
 	"fmt"
+	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/microsoft/ApplicationInsights-Go/appinsights"
@@ -14,8 +14,15 @@ import (
 )
 
 type AppInsightsCore struct {
+	// The Application Insights client used by the default exporter, nil if
+	// constructed with NewAppInsightsCoreWithExporter or if the appinsights
+	// exporter is not enabled in the options
 	Client         appinsights.TelemetryClient
+	exporter       ITelemetryExporter
 	traceExtractor ITraceExtractor
+	initializers   []ITelemetryInitializer
+	processors     []ITelemetryProcessor
+	sampler        ISampler
 	ServName       string
 }
 
@@ -38,6 +45,7 @@ func NewBasic(
 	})
 	return &AppInsightsCore{
 		Client:         client,
+		exporter:       NewAppInsightsExporter(client),
 		ServName:       serviceName,
 		traceExtractor: &DefaultTraceExtractor{},
 	}, nil
@@ -60,31 +68,132 @@ func NewBasicWithLogger(
 	})
 	return &AppInsightsCore{
 		Client:         client,
+		exporter:       NewAppInsightsExporter(client),
 		ServName:       serviceName,
 		traceExtractor: &DefaultTraceExtractor{},
 	}, nil
 }
 
 // Constructs an instance of AppInsightsCore using the provided zap logger and
-// a custom trace extractor, it's recommended to provide a custom trace
-// extractor that will extract the w3c trace information from the context and
-// take advantage of the context dependent trace functions, check documentation
-// of ITraceExtractor for more information
+// a trace extractor that will extract the w3c trace information from the
+// context to take advantage of the context dependent trace functions, check
+// documentation of ITraceExtractor for more information. If traceExtractor is
+// nil the W3CTraceExtractor provided in this package will be used, which reads
+// the trace information injected with ContextWithTraceInfo. The connection
+// string is used over the instrumentation key if provided, should it be
+// invalid the error is logged and the instrumentation key is used instead,
+// unknown exporters are logged and ignored (check
+// NewAppInsightsCoreFromOptions for a validating constructor)
 func NewAppInsightsCore(
 	optn *AppInsightsOptions,
 	traceExtractor ITraceExtractor,
 	lgr *zap.Logger,
 ) *AppInsightsCore {
-	client := appinsights.NewTelemetryClient(optn.InstrumentationKey)
-	appinsights.NewDiagnosticsMessageListener(func(msg string) error {
-		lgr.Info(msg)
-		return nil
-	})
-	return &AppInsightsCore{
+	if traceExtractor == nil {
+		traceExtractor = &W3CTraceExtractor{}
+	}
+	exporterNames := optn.Exporters
+	if len(exporterNames) == 0 {
+		exporterNames = []string{ExporterAppInsights}
+	}
+
+	var client appinsights.TelemetryClient
+	exporters := []ITelemetryExporter{}
+	for _, name := range exporterNames {
+		switch name {
+		case ExporterAppInsights:
+			client = newTelemetryClient(optn, lgr)
+			exporters = append(exporters, NewAppInsightsExporter(client))
+		case ExporterConsole:
+			exporters = append(exporters, NewWriterExporter(os.Stdout))
+		default:
+			lgr.Error("unknown exporter ignored", zap.String("exporter", name))
+		}
+	}
+
+	var exporter ITelemetryExporter
+	if len(exporters) == 1 {
+		exporter = exporters[0]
+	} else {
+		exporter = NewMultiExporter(exporters...)
+	}
+	if optn.TailSampling != nil {
+		exporter = NewTailSamplingExporter(exporter, optn.TailSampling)
+	}
+	ins := &AppInsightsCore{
 		Client:         client,
+		exporter:       exporter,
 		ServName:       optn.ServiceName,
 		traceExtractor: traceExtractor,
 	}
+	if optn.Redaction != nil {
+		redactor, err := NewRedactor(optn.Redaction)
+		if err != nil {
+			lgr.Error("failed to create redactor", zap.Error(err))
+		} else {
+			ins.AddProcessor(redactor)
+		}
+	}
+	if optn.DependencyData != nil {
+		ins.AddProcessor(NewDependencyDataProcessor(optn.DependencyData))
+	}
+	if optn.MaxItemsPerSecond > 0 {
+		ins.UseSampler(NewAdaptiveSampler(&AdaptiveSamplerOptions{
+			MaxItemsPerSecond: optn.MaxItemsPerSecond,
+		}))
+	} else if optn.SamplingPercentage > 0 && optn.SamplingPercentage < 100 {
+		ins.UseSampler(NewFixedRateSampler(optn.SamplingPercentage))
+	}
+	return ins
+}
+
+// Same as NewAppInsightsCore but the options are validated first, check
+// AppInsightsOptions.Validate
+//
+//	optn, err := appinsightstrace.LoadOptions("")
+//	if err != nil {
+//		return err
+//	}
+//	ins, err := appinsightstrace.NewAppInsightsCoreFromOptions(optn, nil, lgr)
+func NewAppInsightsCoreFromOptions(
+	optn *AppInsightsOptions,
+	traceExtractor ITraceExtractor,
+	lgr *zap.Logger,
+) (*AppInsightsCore, error) {
+	if err := optn.Validate(); err != nil {
+		return nil, err
+	}
+	return NewAppInsightsCore(optn, traceExtractor, lgr), nil
+}
+
+func newTelemetryClient(
+	optn *AppInsightsOptions,
+	lgr *zap.Logger,
+) appinsights.TelemetryClient {
+	config := appinsights.NewTelemetryConfiguration(optn.InstrumentationKey)
+	if optn.ConnectionString != "" {
+		cs, err := ParseConnectionString(optn.ConnectionString)
+		if err != nil {
+			lgr.Error(
+				"failed to parse connection string, falling back to instrumentation key",
+				zap.Error(err),
+			)
+		} else {
+			config = cs.TelemetryConfiguration()
+		}
+	}
+	if optn.MaxBatchSize > 0 {
+		config.MaxBatchSize = optn.MaxBatchSize
+	}
+	if optn.MaxBatchInterval > 0 {
+		config.MaxBatchInterval = optn.MaxBatchInterval
+	}
+	client := appinsights.NewTelemetryClientFromConfig(config)
+	appinsights.NewDiagnosticsMessageListener(func(msg string) error {
+		lgr.Info(msg)
+		return nil
+	})
+	return client
 }
 
 // Same as NewAppInsightsCorenbut without options, instead just taking values
@@ -95,6 +204,9 @@ func NewAppInsightsCoreFlatOptions(
 	traceExtractor ITraceExtractor,
 	lgr *zap.Logger,
 ) *AppInsightsCore {
+	if traceExtractor == nil {
+		traceExtractor = &W3CTraceExtractor{}
+	}
 	client := appinsights.NewTelemetryClient(instrumentationKey)
 	appinsights.NewDiagnosticsMessageListener(func(msg string) error {
 		lgr.Info(msg)
@@ -102,18 +214,70 @@ func NewAppInsightsCoreFlatOptions(
 	})
 	return &AppInsightsCore{
 		Client:         client,
+		exporter:       NewAppInsightsExporter(client),
 		ServName:       serviceName,
 		traceExtractor: traceExtractor,
 	}
 }
 
-func (insights *AppInsightsCore) Close() {
-	select {
-	case <-insights.Client.Channel().Close(10 * time.Second):
-	case <-time.After(30 * time.Second):
+// Same as NewAppInsightsCoreFlatOptions but using a connection string, which
+// also configures the ingestion endpoint, instead of an instrumentation key,
+// an error is returned if the connection string is invalid
+func NewAppInsightsCoreWithConnectionString(
+	connectionString string,
+	serviceName string,
+	traceExtractor ITraceExtractor,
+	lgr *zap.Logger,
+) (*AppInsightsCore, error) {
+	cs, err := ParseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	if traceExtractor == nil {
+		traceExtractor = &W3CTraceExtractor{}
+	}
+	client := appinsights.NewTelemetryClientFromConfig(cs.TelemetryConfiguration())
+	appinsights.NewDiagnosticsMessageListener(func(msg string) error {
+		lgr.Info(msg)
+		return nil
+	})
+	return &AppInsightsCore{
+		Client:         client,
+		exporter:       NewAppInsightsExporter(client),
+		ServName:       serviceName,
+		traceExtractor: traceExtractor,
+	}, nil
+}
+
+// Constructs an instance of AppInsightsCore that sends all telemetry to the
+// provided exporter instead of the Application Insights client, check
+// documentation of ITelemetryExporter for more information. If traceExtractor
+// is nil the W3CTraceExtractor provided in this package will be used
+func NewAppInsightsCoreWithExporter(
+	serviceName string,
+	exporter ITelemetryExporter,
+	traceExtractor ITraceExtractor,
+) *AppInsightsCore {
+	if traceExtractor == nil {
+		traceExtractor = &W3CTraceExtractor{}
+	}
+	return &AppInsightsCore{
+		exporter:       exporter,
+		ServName:       serviceName,
+		traceExtractor: traceExtractor,
 	}
 }
 
+// Sends any queued telemetry and closes the exporter
+func (insights *AppInsightsCore) Close() {
+	insights.exporter.Close()
+}
+
+// Forces any queued telemetry to be sent
+func (ins *AppInsightsCore) Flush() {
+	ins.exporter.Flush()
+}
+
 func (ins *AppInsightsCore) ExtractTraceInfo(
 	ctx context.Context,
 ) (ver, tid, pid, rid, flg string) {
@@ -142,6 +306,14 @@ func (ins *AppInsightsCore) ExtractTraceInfo(
 // eventTimestamp: timestamp of when the request has completed processing by this
 //
 //	service
+//
+// fields: additional custom values to include in the telemetry, may be nil,
+//
+//	the map is copied and never modified, the bodySize, ip and userAgent
+//	parameters take precedence over the keys of the same name
+//
+// Measurements can't be provided through this function, use TraceRequestInfo
+// instead
 func (ins *AppInsightsCore) TraceRequest(
 	ctx context.Context,
 	method string,
@@ -156,35 +328,21 @@ func (ins *AppInsightsCore) TraceRequest(
 	fields map[string]string,
 ) {
 	_, tid, pid, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	props["bodySize"] = strconv.Itoa(bodySize)
-	props["ip"] = ip
-	props["userAgent"] = userAgent
-	name := fmt.Sprintf("%s %s", method, path)
-	tele := appinsights.RequestTelemetry{
-		Name:         name,
-		Url:          fmt.Sprintf("%s%s", path, query),
-		Id:           rid,
-		Duration:     eventTimestamp.Sub(startTimestamp),
-		ResponseCode: strconv.Itoa(statusCode),
-		Success:      statusCode > 99 && statusCode < 300,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(pid)
-	tele.Tags.Operation().SetName(name)
-
-	ins.Client.Track(&tele)
+	ins.TraceRequestWithIds(
+		tid,
+		pid,
+		rid,
+		method,
+		path,
+		query,
+		statusCode,
+		bodySize,
+		ip,
+		userAgent,
+		startTimestamp,
+		eventTimestamp,
+		fields,
+	)
 }
 
 // - Context dependent
@@ -208,7 +366,13 @@ func (ins *AppInsightsCore) TraceRequest(
 // startTimestamp: timestamp of when the request was received by this service
 // eventTimestamp: timestamp of when the request has completed processing by this
 // service
-// fields: additional custom values to include in the telemetry
+// fields: additional custom values to include in the telemetry, may be nil,
+//
+//	the map is copied and never modified, the bodySize, ip and userAgent
+//	parameters take precedence over the keys of the same name
+//
+// Measurements can't be provided through this function, use TracePageViewInfo
+// instead
 func (ins *AppInsightsCore) TracePageView(
 	ctx context.Context,
 	path string,
@@ -221,35 +385,18 @@ func (ins *AppInsightsCore) TracePageView(
 	fields map[string]string,
 ) {
 	_, tid, pid, _, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	props["bodySize"] = strconv.Itoa(bodySize)
-	props["ip"] = ip
-	props["userAgent"] = userAgent
-	bldr := strings.Builder{} // look i did the abbreviation thing
-	bldr.WriteString("GET ")
-	bldr.WriteString(path)
-	name := bldr.String()
-	tele := appinsights.PageViewTelemetry{
-		Name:     name,
-		Url:      path,
-		Duration: eventTimestamp.Sub(startTimestamp),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(pid)
-	tele.Tags.Operation().SetName(name)
-
-	ins.Client.Track(&tele)
+	ins.TracePageViewWithIds(
+		tid,
+		pid,
+		path,
+		statusCode,
+		bodySize,
+		ip,
+		userAgent,
+		startTimestamp,
+		eventTimestamp,
+		fields,
+	)
 }
 
 // - Context Independent
@@ -273,7 +420,13 @@ func (ins *AppInsightsCore) TracePageView(
 // startTimestamp: timestamp of when the request was received by this service
 // eventTimestamp: timestamp of when the request has completed processing by this
 // service
-// fields: additional custom values to include in the telemetry
+// fields: additional custom values to include in the telemetry, may be nil,
+//
+//	the map is copied and never modified, the bodySize, ip and userAgent
+//	parameters take precedence over the keys of the same name
+//
+// Measurements can't be provided through this function, use TracePageViewInfo
+// instead
 func (ins *AppInsightsCore) TracePageViewWithIds(
 	traceId string,
 	parentId string,
@@ -286,34 +439,16 @@ func (ins *AppInsightsCore) TracePageViewWithIds(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	props := fields
-	props["bodySize"] = strconv.Itoa(bodySize)
-	props["ip"] = ip
-	props["userAgent"] = userAgent
-	bldr := strings.Builder{} // look i did the abbreviation thing
-	bldr.WriteString("GET ")
-	bldr.WriteString(path)
-	name := bldr.String()
-	tele := appinsights.PageViewTelemetry{
-		Name:     name,
-		Url:      path,
-		Duration: eventTimestamp.Sub(startTimestamp),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(parentId)
-	tele.Tags.Operation().SetName(name)
-
-	ins.Client.Track(&tele)
+	tele := ins.newPageViewTelemetry(PageViewInfo{
+		TraceId:        traceId,
+		ParentId:       parentId,
+		Path:           path,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
+	setRequestFields(tele.Properties, bodySize, ip, userAgent)
+	ins.track(tele)
 }
 
 // Transmits a new Request telemtery for events, this should be used to trace incoming
@@ -341,33 +476,14 @@ func (ins *AppInsightsCore) TraceEvent(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	_, tid, pid, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	name = fmt.Sprintf("%s %s", name, key)
-	tele := appinsights.RequestTelemetry{
-		Name:         name,
-		Url:          key,
-		Id:           rid,
-		Duration:     eventTimestamp.Sub(startTimestamp),
-		ResponseCode: strconv.Itoa(statusCode),
-		Success:      statusCode > 99 && statusCode < 300,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(pid)
-	tele.Tags.Operation().SetName(name)
-
-	ins.Client.Track(&tele)
+	ins.TraceRequestInfo(ctx, RequestInfo{
+		Name:           fmt.Sprintf("%s %s", name, key),
+		Url:            key,
+		StatusCode:     statusCode,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
 }
 
 // Transmits a new Dependency telemtery, this should be used to trace outgoing
@@ -397,6 +513,9 @@ func (ins *AppInsightsCore) TraceEvent(
 //
 // eventTimestamp: timestamp of when the dependency has been completed
 // fields: additional custom values to include in the telemetry
+//
+// Measurements can't be provided through this function, use TraceDependencyInfo
+// instead
 func (ins *AppInsightsCore) TraceDependency(
 	ctx context.Context,
 	spanId string,
@@ -408,30 +527,16 @@ func (ins *AppInsightsCore) TraceDependency(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	tele := &appinsights.RemoteDependencyTelemetry{
-		Id:       spanId,
-		Name:     commandName,
-		Type:     dependencyType,
-		Target:   serviceName,
-		Success:  success,
-		Duration: eventTimestamp.Sub(startTimestamp),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(rid)
-	tele.Tags.Operation().SetName(commandName)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+	ins.TraceDependencyInfo(ctx, DependencyInfo{
+		SpanId:         spanId,
+		Type:           dependencyType,
+		Target:         serviceName,
+		Name:           commandName,
+		Success:        success,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
 }
 
 // Transmits a new trace log telemetry.
@@ -455,22 +560,11 @@ func (ins *AppInsightsCore) TraceLog(
 	severityLevel SeverityLevel,
 	fields map[string]string,
 ) {
-	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	tele := &appinsights.TraceTelemetry{
+	ins.TraceLogInfo(ctx, LogInfo{
 		Message:       message,
-		SeverityLevel: contracts.SeverityLevel(severityLevel),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  time.Now(),
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-	}
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(rid)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+		SeverityLevel: severityLevel,
+		Fields:        fields,
+	})
 }
 
 // Transmits a new exception telemetry, should be used to track unexpected errors
@@ -484,38 +578,22 @@ func (ins *AppInsightsCore) TraceLog(
 //
 // err: the unexpected error object
 // skip: the number of levels to skip on the call stack (set to 0 if unsure)
-// severityLevel: the severity level (Critical, Error, Warning, Information,
-//
-//	Verbose), it's recommended to use the constants of the same name provided
-//	in this package
-//
 // fields: additional custom values to include in the telemetry
+//
+// The exception is sent with the Error severity level along with the errors
+// wrapped by err, use TraceExceptionInfo to set the severity level, the
+// problem id or measurements
 func (ins *AppInsightsCore) TraceException(
 	ctx context.Context,
 	err interface{},
 	skip int,
 	fields map[string]string,
 ) {
-	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
-
-	props := fields
-	tele := &appinsights.ExceptionTelemetry{
-		Error:         err,
-		Frames:        appinsights.GetCallstack(2 + skip),
-		SeverityLevel: appinsights.Error,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  time.Now(),
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-	tele.Tags.Operation().SetId(tid)
-	tele.Tags.Operation().SetParentId(rid)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+	ins.TraceExceptionInfo(ctx, ExceptionInfo{
+		Error:  err,
+		Skip:   skip + 1,
+		Fields: fields,
+	})
 }
 
 // - Context Independent
@@ -550,7 +628,13 @@ func (ins *AppInsightsCore) TraceException(
 //
 //	service
 //
-// fields: additional custom values to include in the telemetry
+// fields: additional custom values to include in the telemetry, may be nil,
+//
+//	the map is copied and never modified, the bodySize, ip and userAgent
+//	parameters take precedence over the keys of the same name
+//
+// Measurements can't be provided through this function, use TraceRequestInfo
+// instead
 func (ins *AppInsightsCore) TraceRequestWithIds(
 	traceId string,
 	parentId string,
@@ -566,34 +650,63 @@ func (ins *AppInsightsCore) TraceRequestWithIds(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	props := fields
+	tele := ins.newRequestTelemetry(RequestInfo{
+		TraceId:        traceId,
+		ParentId:       parentId,
+		RequestId:      requestId,
+		Method:         method,
+		Path:           path,
+		Query:          query,
+		StatusCode:     statusCode,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
+	setRequestFields(tele.Properties, bodySize, ip, userAgent)
+	ins.track(tele)
+}
+
+// Sets the bodySize, ip and userAgent properties of TraceRequest and
+// TracePageView on the (already copied) properties of the telemetry, they
+// take precedence over the fields of the same name
+func setRequestFields(
+	props map[string]string,
+	bodySize int,
+	ip string,
+	userAgent string,
+) {
 	props["bodySize"] = strconv.Itoa(bodySize)
 	props["ip"] = ip
 	props["userAgent"] = userAgent
-	name := fmt.Sprintf("%s %s", method, path)
-	tele := appinsights.RequestTelemetry{
-		Name:         name,
-		Url:          fmt.Sprintf("%s%s", path, query),
-		Id:           requestId,
-		Duration:     eventTimestamp.Sub(startTimestamp),
-		ResponseCode: strconv.Itoa(statusCode),
-		Success:      statusCode > 99 && statusCode < 300,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(parentId)
-	tele.Tags.Operation().SetName(name)
+}
 
-	ins.Client.Track(&tele)
+// Builds and transmits the Request telemetry for TraceRequest,
+// TraceRequestWithIds and the middlewares, name is the operation name
+// (ex: GET /api/v1/users/{id}) and url the actual url of the request
+func (ins *AppInsightsCore) traceRequest(
+	traceId string,
+	parentId string,
+	requestId string,
+	name string,
+	url string,
+	responseCode string,
+	success bool,
+	startTimestamp time.Time,
+	eventTimestamp time.Time,
+	fields map[string]string,
+) {
+	ins.traceRequestInfo(RequestInfo{
+		TraceId:        traceId,
+		ParentId:       parentId,
+		RequestId:      requestId,
+		Name:           name,
+		Url:            url,
+		ResponseCode:   responseCode,
+		Success:        &success,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
 }
 
 // Transmits a new Request telemtery for events, this should be used to trace incoming
@@ -629,31 +742,17 @@ func (ins *AppInsightsCore) TraceEventWithIds(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	props := fields
-	name = fmt.Sprintf("%s %s", name, key)
-	tele := appinsights.RequestTelemetry{
-		Name:         name,
-		Url:          key,
-		Id:           requestId,
-		Duration:     eventTimestamp.Sub(startTimestamp),
-		ResponseCode: strconv.Itoa(statusCode),
-		Success:      statusCode > 99 && statusCode < 300,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(parentId)
-	tele.Tags.Operation().SetName(name)
-
-	ins.Client.Track(&tele)
+	ins.traceRequestInfo(RequestInfo{
+		TraceId:        traceId,
+		ParentId:       parentId,
+		RequestId:      requestId,
+		Name:           fmt.Sprintf("%s %s", name, key),
+		Url:            key,
+		StatusCode:     statusCode,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
 }
 
 // Transmits a new Dependency telemtery, this should be used to trace outgoing
@@ -686,6 +785,9 @@ func (ins *AppInsightsCore) TraceEventWithIds(
 //
 // eventTimestamp: timestamp of when the dependency has been completed
 // fields: additional custom values to include in the telemetry
+//
+// Measurements can't be provided through this function, use TraceDependencyInfo
+// instead
 func (ins *AppInsightsCore) TraceDependencyWithIds(
 	traceId string,
 	requestId string,
@@ -698,28 +800,51 @@ func (ins *AppInsightsCore) TraceDependencyWithIds(
 	eventTimestamp time.Time,
 	fields map[string]string,
 ) {
-	props := fields
-	tele := &appinsights.RemoteDependencyTelemetry{
-		Id:       spanId,
-		Name:     commandName,
-		Type:     dependencyType,
-		Target:   serviceName,
-		Success:  success,
-		Duration: eventTimestamp.Sub(startTimestamp),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  startTimestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(requestId)
-	tele.Tags.Operation().SetName(commandName)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+	ins.traceDependencyInfo(DependencyInfo{
+		TraceId:        traceId,
+		ParentId:       requestId,
+		SpanId:         spanId,
+		Type:           dependencyType,
+		Target:         serviceName,
+		Name:           commandName,
+		Success:        success,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
+}
+
+// Builds and transmits the Dependency telemetry for TraceDependency,
+// TraceDependencyWithIds and the http transport, resultCode and data (the
+// full command, ex: the url of an outgoing http request) may be left empty
+func (ins *AppInsightsCore) traceDependency(
+	traceId string,
+	requestId string,
+	spanId string,
+	dependencyType string,
+	serviceName string,
+	commandName string,
+	resultCode string,
+	data string,
+	success bool,
+	startTimestamp time.Time,
+	eventTimestamp time.Time,
+	fields map[string]string,
+) {
+	ins.traceDependencyInfo(DependencyInfo{
+		TraceId:        traceId,
+		ParentId:       requestId,
+		SpanId:         spanId,
+		Type:           dependencyType,
+		Target:         serviceName,
+		Name:           commandName,
+		ResultCode:     resultCode,
+		Data:           data,
+		Success:        success,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   eventTimestamp,
+		Fields:         fields,
+	})
 }
 
 // Transmits a new trace log telemetry. It uses the provied traceId and requestId
@@ -748,20 +873,14 @@ func (ins *AppInsightsCore) TraceLogWithIds(
 	timestamp time.Time,
 	fields map[string]string,
 ) {
-	props := fields
-	tele := &appinsights.TraceTelemetry{
+	ins.traceLogInfo(LogInfo{
+		TraceId:       traceId,
+		ParentId:      requestId,
 		Message:       message,
-		SeverityLevel: contracts.SeverityLevel(severityLevel),
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  timestamp,
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-	}
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(requestId)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+		SeverityLevel: SeverityLevel(severityLevel),
+		Timestamp:     timestamp,
+		Fields:        fields,
+	})
 }
 
 // Transmits a new exception telemetry, should be used to track unexpected errors
@@ -778,12 +897,11 @@ func (ins *AppInsightsCore) TraceLogWithIds(
 //
 // err: the unexpected error object
 // skip: the number of levels to skip on the call stack (set to 0 if unsure)
-// severityLevel: the severity level (Critical, Error, Warning, Information,
-//
-//	Verbose), it's recommended to use the constants of the same name provided
-//	in this package
-//
 // fields: additional custom values to include in the telemetry
+//
+// The exception is sent with the Error severity level along with the errors
+// wrapped by err, use TraceExceptionInfo to set the severity level, the
+// problem id or measurements
 func (ins *AppInsightsCore) TraceExceptionWithIds(
 	traceId string,
 	requestId string,
@@ -791,22 +909,11 @@ func (ins *AppInsightsCore) TraceExceptionWithIds(
 	skip int,
 	fields map[string]string,
 ) {
-	props := fields
-	tele := &appinsights.ExceptionTelemetry{
-		Error:         err,
-		Frames:        appinsights.GetCallstack(2 + skip),
-		SeverityLevel: appinsights.Error,
-		BaseTelemetry: appinsights.BaseTelemetry{
-			Timestamp:  time.Now(),
-			Tags:       make(contracts.ContextTags),
-			Properties: props,
-		},
-		BaseTelemetryMeasurements: appinsights.BaseTelemetryMeasurements{
-			Measurements: make(map[string]float64),
-		},
-	}
-	tele.Tags.Operation().SetId(traceId)
-	tele.Tags.Operation().SetParentId(requestId)
-	tele.Tags.Cloud().SetRole(ins.ServName)
-	ins.Client.Track(tele)
+	ins.traceExceptionInfo(ExceptionInfo{
+		TraceId:  traceId,
+		ParentId: requestId,
+		Error:    err,
+		Skip:     skip,
+		Fields:   fields,
+	}, nil)
 }
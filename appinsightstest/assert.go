@@ -0,0 +1,336 @@
+package appinsightstest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+// Fails the test if no request telemetry with the name was recorded, returns
+// the first matching request
+func (rec *Recorder) RequireRequest(
+	t testing.TB,
+	name string,
+) *ait.RequestTelemetry {
+	t.Helper()
+	for _, req := range rec.Requests() {
+		if req.Name == name {
+			return req
+		}
+	}
+	t.Fatalf("expected a request named %q, recorded:\n%s", name, rec.summary())
+	return nil
+}
+
+// Fails the test if no dependency telemetry with the name was recorded,
+// returns the first matching dependency
+func (rec *Recorder) RequireDependency(
+	t testing.TB,
+	name string,
+) *ait.DependencyTelemetry {
+	t.Helper()
+	for _, dep := range rec.Dependencies() {
+		if dep.Name == name {
+			return dep
+		}
+	}
+	t.Fatalf("expected a dependency named %q, recorded:\n%s", name, rec.summary())
+	return nil
+}
+
+// Fails the test if no dependency telemetry was recorded as a direct child of
+// the parent id (ex: the Id of a request), returns the first matching
+// dependency
+func (rec *Recorder) RequireDependencyUnder(
+	t testing.TB,
+	parentId string,
+) *ait.DependencyTelemetry {
+	t.Helper()
+	for _, dep := range rec.Dependencies() {
+		if dep.ParentId == parentId {
+			return dep
+		}
+	}
+	t.Fatalf(
+		"expected a dependency under parent %q, recorded:\n%s",
+		parentId,
+		rec.summary(),
+	)
+	return nil
+}
+
+// Fails the test if no trace log telemetry containing the message was
+// recorded, returns the first matching trace
+func (rec *Recorder) RequireTrace(
+	t testing.TB,
+	message string,
+) *ait.TraceTelemetry {
+	t.Helper()
+	for _, trace := range rec.Traces() {
+		if strings.Contains(trace.Message, message) {
+			return trace
+		}
+	}
+	t.Fatalf("expected a trace containing %q, recorded:\n%s", message, rec.summary())
+	return nil
+}
+
+// Fails the test if no exception telemetry was recorded, returns the first
+// exception
+func (rec *Recorder) RequireException(
+	t testing.TB,
+) *ait.ExceptionTelemetry {
+	t.Helper()
+	exceptions := rec.Exceptions()
+	if len(exceptions) == 0 {
+		t.Fatalf("expected an exception, recorded:\n%s", rec.summary())
+		return nil
+	}
+	return exceptions[0]
+}
+
+// Fails the test if no custom event telemetry with the name was recorded,
+// returns the first matching event
+func (rec *Recorder) RequireEvent(
+	t testing.TB,
+	name string,
+) *ait.EventTelemetry {
+	t.Helper()
+	for _, event := range rec.Events() {
+		if event.Name == name {
+			return event
+		}
+	}
+	t.Fatalf("expected an event named %q, recorded:\n%s", name, rec.summary())
+	return nil
+}
+
+// Fails the test if the number of recorded items is not count
+func (rec *Recorder) RequireCount(t testing.TB, count int) {
+	t.Helper()
+	if items := rec.Items(); len(items) != count {
+		t.Fatalf(
+			"expected %d items, recorded %d:\n%s",
+			count,
+			len(items),
+			rec.summary(),
+		)
+	}
+}
+
+// Fails the test if the recorded items do not all belong to a single
+// operation (trace id), returns the operation id
+func (rec *Recorder) RequireSingleOperation(t testing.TB) string {
+	t.Helper()
+	items := rec.Items()
+	if len(items) == 0 {
+		t.Fatalf("expected telemetry for a single operation, recorded none")
+		return ""
+	}
+	operationId := items[0].Base().OperationId
+	for _, item := range items[1:] {
+		if item.Base().OperationId != operationId {
+			t.Fatalf(
+				"expected all items to belong to operation %q, recorded:\n%s",
+				operationId,
+				rec.summary(),
+			)
+			return ""
+		}
+	}
+	return operationId
+}
+
+// - Trace tree
+
+// Node of a trace tree built from the recorded items
+type TraceNode struct {
+	Item     ait.ITelemetry
+	Children []*TraceNode
+}
+
+// Expected shape of a trace tree, Name is matched against the name of
+// requests, dependencies, page views and events, the message of traces and
+// the error message of exceptions. Children are matched regardless of order
+type TreeSpec struct {
+	Name     string
+	Children []TreeSpec
+}
+
+// Builds the trace trees of the operation, items are linked to their parent
+// through the ParentId and the Id of requests and dependencies, items whose
+// parent was not recorded are returned as roots
+func (rec *Recorder) TraceTree(operationId string) []*TraceNode {
+	items := rec.Operation(operationId)
+	nodes := make([]*TraceNode, len(items))
+	byId := map[string]*TraceNode{}
+	for i, item := range items {
+		nodes[i] = &TraceNode{Item: item}
+		if id := itemId(item); id != "" {
+			byId[id] = nodes[i]
+		}
+	}
+	roots := []*TraceNode{}
+	for _, node := range nodes {
+		parent, ok := byId[node.Item.Base().ParentId]
+		if ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// Fails the test if the operation does not contain a trace tree (starting at
+// any of its roots) matching the spec, extra recorded children are allowed
+func (rec *Recorder) RequireTraceTree(
+	t testing.TB,
+	operationId string,
+	spec TreeSpec,
+) *TraceNode {
+	t.Helper()
+	roots := rec.TraceTree(operationId)
+	for _, root := range roots {
+		if matchTree(root, spec) {
+			return root
+		}
+	}
+	bldr := strings.Builder{}
+	for _, root := range roots {
+		writeTree(&bldr, root, 0)
+	}
+	t.Fatalf(
+		"expected trace tree for operation %q not found, recorded:\n%s",
+		operationId,
+		bldr.String(),
+	)
+	return nil
+}
+
+func matchTree(node *TraceNode, spec TreeSpec) bool {
+	if ItemName(node.Item) != spec.Name {
+		return false
+	}
+	used := make([]bool, len(node.Children))
+	return matchChildren(node.Children, spec.Children, used)
+}
+
+// Backtracking match so each spec child is matched to a distinct node
+func matchChildren(
+	children []*TraceNode,
+	specs []TreeSpec,
+	used []bool,
+) bool {
+	if len(specs) == 0 {
+		return true
+	}
+	for i, child := range children {
+		if used[i] || !matchTree(child, specs[0]) {
+			continue
+		}
+		used[i] = true
+		if matchChildren(children, specs[1:], used) {
+			return true
+		}
+		used[i] = false
+	}
+	return false
+}
+
+// Returns the name used to identify the item in assertions, check TreeSpec
+func ItemName(item ait.ITelemetry) string {
+	switch tele := item.(type) {
+	case *ait.RequestTelemetry:
+		return tele.Name
+	case *ait.DependencyTelemetry:
+		return tele.Name
+	case *ait.PageViewTelemetry:
+		return tele.Name
+	case *ait.EventTelemetry:
+		return tele.Name
+	case *ait.MetricTelemetry:
+		return tele.Name
+	case *ait.TraceTelemetry:
+		return tele.Message
+	case *ait.ExceptionTelemetry:
+		if tele.Message != "" {
+			return tele.Message
+		}
+		if err, ok := tele.Error.(error); ok {
+			return err.Error()
+		}
+		return fmt.Sprint(tele.Error)
+	default:
+		return ""
+	}
+}
+
+func itemId(item ait.ITelemetry) string {
+	switch tele := item.(type) {
+	case *ait.RequestTelemetry:
+		return tele.Id
+	case *ait.DependencyTelemetry:
+		return tele.Id
+	default:
+		return ""
+	}
+}
+
+func itemKind(item ait.ITelemetry) string {
+	switch item.(type) {
+	case *ait.RequestTelemetry:
+		return "request"
+	case *ait.DependencyTelemetry:
+		return "dependency"
+	case *ait.PageViewTelemetry:
+		return "pageView"
+	case *ait.EventTelemetry:
+		return "event"
+	case *ait.MetricTelemetry:
+		return "metric"
+	case *ait.TraceTelemetry:
+		return "trace"
+	case *ait.ExceptionTelemetry:
+		return "exception"
+	default:
+		return fmt.Sprintf("%T", item)
+	}
+}
+
+func writeTree(bldr *strings.Builder, node *TraceNode, depth int) {
+	bldr.WriteString(strings.Repeat("  ", depth))
+	bldr.WriteString(describe(node.Item))
+	bldr.WriteByte('\n')
+	for _, child := range node.Children {
+		writeTree(bldr, child, depth+1)
+	}
+}
+
+func describe(item ait.ITelemetry) string {
+	base := item.Base()
+	return fmt.Sprintf(
+		"%s %q id=%q operation=%q parent=%q",
+		itemKind(item),
+		ItemName(item),
+		itemId(item),
+		base.OperationId,
+		base.ParentId,
+	)
+}
+
+func (rec *Recorder) summary() string {
+	items := rec.Items()
+	if len(items) == 0 {
+		return "  (none)"
+	}
+	bldr := strings.Builder{}
+	for _, item := range items {
+		bldr.WriteString("  ")
+		bldr.WriteString(describe(item))
+		bldr.WriteByte('\n')
+	}
+	return bldr.String()
+}
@@ -0,0 +1,168 @@
+package appinsightstest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+// testing.TB recording failures instead of stopping the test
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Fatalf(format string, args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Fatal(args ...interface{}) {
+	tb.failures = append(tb.failures, fmt.Sprint(args...))
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// Records the operation of the README example, a request to /weather calling
+// api.weather.com/forecast
+func recordWeatherOperation(t *testing.T) *Recorder {
+	tracer, rec := NewCore("WeatherService")
+	client := &http.Client{
+		Transport: tracer.HttpTransport(roundTripperFunc(
+			func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("{}")),
+					Request:    req,
+				}, nil
+			},
+		)),
+	}
+	handler := tracer.HttpMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequestWithContext(
+				r.Context(),
+				"GET",
+				"http://api.weather.com/forecast",
+				nil,
+			)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			resp.Body.Close()
+		},
+	))
+	handler.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/weather", nil),
+	)
+	return rec
+}
+
+func TestRequireTraceTreeExample(t *testing.T) {
+	rec := recordWeatherOperation(t)
+
+	req := rec.RequireRequest(t, "GET /weather")
+	dep := rec.RequireDependencyUnder(t, req.Id)
+	if dep.Name != "GET api.weather.com/forecast" {
+		t.Errorf("unexpected dependency %q", dep.Name)
+	}
+	root := rec.RequireTraceTree(t, req.OperationId, TreeSpec{
+		Name:     "GET /weather",
+		Children: []TreeSpec{{Name: "GET api.weather.com/forecast"}},
+	})
+	if root.Item != req {
+		t.Errorf("unexpected root %s", describe(root.Item))
+	}
+	rec.RequireCount(t, 2)
+	if id := rec.RequireSingleOperation(t); id != req.OperationId {
+		t.Errorf("unexpected operation %q", id)
+	}
+}
+
+// Records a request with two children named "query", the first of which has
+// a child named "retry"
+func recordBacktrackingOperation() *Recorder {
+	rec := NewRecorder()
+	base := func(parentId string) ait.TelemetryBase {
+		return ait.TelemetryBase{OperationId: "op", ParentId: parentId}
+	}
+	rec.Export(&ait.RequestTelemetry{TelemetryBase: base(""), Id: "req", Name: "root"})
+	rec.Export(&ait.DependencyTelemetry{TelemetryBase: base("req"), Id: "q1", Name: "query"})
+	rec.Export(&ait.DependencyTelemetry{TelemetryBase: base("req"), Id: "q2", Name: "query"})
+	rec.Export(&ait.DependencyTelemetry{TelemetryBase: base("q1"), Id: "r1", Name: "retry"})
+	return rec
+}
+
+func TestRequireTraceTreeBacktracking(t *testing.T) {
+	rec := recordBacktrackingOperation()
+
+	// a greedy match assigns the first spec to q1 and fails on the second
+	rec.RequireTraceTree(t, "op", TreeSpec{
+		Name: "root",
+		Children: []TreeSpec{
+			{Name: "query"},
+			{Name: "query", Children: []TreeSpec{{Name: "retry"}}},
+		},
+	})
+}
+
+func TestRequireTraceTreeFailure(t *testing.T) {
+	rec := recordBacktrackingOperation()
+
+	cases := map[string]TreeSpec{
+		"wrong root": {Name: "other"},
+		"missing child": {
+			Name:     "root",
+			Children: []TreeSpec{{Name: "insert"}},
+		},
+		"children not distinct": {
+			Name: "root",
+			Children: []TreeSpec{
+				{Name: "query", Children: []TreeSpec{{Name: "retry"}}},
+				{Name: "query", Children: []TreeSpec{{Name: "retry"}}},
+			},
+		},
+	}
+	for name, spec := range cases {
+		t.Run(name, func(t *testing.T) {
+			tb := &fakeTB{TB: t}
+			if root := rec.RequireTraceTree(tb, "op", spec); root != nil {
+				t.Errorf("unexpected match %s", describe(root.Item))
+			}
+			if len(tb.failures) != 1 {
+				t.Fatalf("expected 1 failure, got %d", len(tb.failures))
+			}
+			if !strings.Contains(tb.failures[0], `dependency "retry"`) {
+				t.Errorf("failure does not describe the recorded tree:\n%s", tb.failures[0])
+			}
+		})
+	}
+}
+
+func TestRequireDependencyUnderFailure(t *testing.T) {
+	rec := recordBacktrackingOperation()
+
+	if dep := rec.RequireDependencyUnder(t, "q1"); dep.Id != "r1" {
+		t.Errorf("unexpected dependency %q", dep.Id)
+	}
+	tb := &fakeTB{TB: t}
+	if dep := rec.RequireDependencyUnder(tb, "unknown"); dep != nil {
+		t.Errorf("unexpected dependency %q", dep.Id)
+	}
+	if len(tb.failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d", len(tb.failures))
+	}
+}
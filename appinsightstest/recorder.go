@@ -0,0 +1,125 @@
+// Package appinsightstest provides an in-memory ITelemetryExporter recording
+// every telemetry item produced by an AppInsightsCore along with assertion
+// helpers, so instrumentation can be verified in unit tests without sending
+// anything to Application Insights
+package appinsightstest
+
+import (
+	"sync"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+// ITelemetryExporter that records every exported telemetry item in order
+type Recorder struct {
+	mtx    sync.Mutex
+	items  []ait.ITelemetry
+	closed bool
+}
+
+var _ ait.ITelemetryExporter = (*Recorder)(nil)
+
+// Constructs an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Constructs an AppInsightsCore (with the W3CTraceExtractor) that sends all
+// telemetry to a new Recorder
+func NewCore(serviceName string) (*ait.AppInsightsCore, *Recorder) {
+	rec := NewRecorder()
+	return ait.NewAppInsightsCoreWithExporter(serviceName, rec, nil), rec
+}
+
+func (rec *Recorder) Export(item ait.ITelemetry) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	rec.items = append(rec.items, item)
+}
+
+func (rec *Recorder) Flush() {}
+
+func (rec *Recorder) Close() {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	rec.closed = true
+}
+
+// Whether Close has been called on the recorder
+func (rec *Recorder) IsClosed() bool {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	return rec.closed
+}
+
+// Removes all of the recorded items
+func (rec *Recorder) Reset() {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	rec.items = nil
+}
+
+// Returns all of the recorded items in the order they were exported
+func (rec *Recorder) Items() []ait.ITelemetry {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	items := make([]ait.ITelemetry, len(rec.items))
+	copy(items, rec.items)
+	return items
+}
+
+// Returns the recorded request telemetry in order
+func (rec *Recorder) Requests() []*ait.RequestTelemetry {
+	return filterItems[*ait.RequestTelemetry](rec.Items())
+}
+
+// Returns the recorded page view telemetry in order
+func (rec *Recorder) PageViews() []*ait.PageViewTelemetry {
+	return filterItems[*ait.PageViewTelemetry](rec.Items())
+}
+
+// Returns the recorded dependency telemetry in order
+func (rec *Recorder) Dependencies() []*ait.DependencyTelemetry {
+	return filterItems[*ait.DependencyTelemetry](rec.Items())
+}
+
+// Returns the recorded trace log telemetry in order
+func (rec *Recorder) Traces() []*ait.TraceTelemetry {
+	return filterItems[*ait.TraceTelemetry](rec.Items())
+}
+
+// Returns the recorded exception telemetry in order
+func (rec *Recorder) Exceptions() []*ait.ExceptionTelemetry {
+	return filterItems[*ait.ExceptionTelemetry](rec.Items())
+}
+
+// Returns the recorded metric telemetry in order
+func (rec *Recorder) Metrics() []*ait.MetricTelemetry {
+	return filterItems[*ait.MetricTelemetry](rec.Items())
+}
+
+// Returns the recorded custom event telemetry in order
+func (rec *Recorder) Events() []*ait.EventTelemetry {
+	return filterItems[*ait.EventTelemetry](rec.Items())
+}
+
+// Returns the recorded items belonging to the operation (trace id) in order
+func (rec *Recorder) Operation(operationId string) []ait.ITelemetry {
+	items := []ait.ITelemetry{}
+	for _, item := range rec.Items() {
+		if item.Base().OperationId == operationId {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func filterItems[T ait.ITelemetry](items []ait.ITelemetry) []T {
+	filtered := []T{}
+	for _, item := range items {
+		if tele, ok := item.(T); ok {
+			filtered = append(filtered, tele)
+		}
+	}
+	return filtered
+}
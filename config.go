@@ -0,0 +1,254 @@
+package appinsightstrace
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Environment variables read by LoadOptions
+const (
+	EnvConnectionString   = "APPLICATIONINSIGHTS_CONNECTION_STRING"
+	EnvInstrumentationKey = "APPINSIGHTS_INSTRUMENTATIONKEY"
+	EnvServiceName        = "OTEL_SERVICE_NAME"
+	EnvConfigFile         = "APPINSIGHTSTRACE_CONFIG_FILE"
+	EnvMaxBatchSize       = "APPINSIGHTSTRACE_MAX_BATCH_SIZE"
+	EnvMaxBatchInterval   = "APPINSIGHTSTRACE_MAX_BATCH_INTERVAL"
+	EnvSamplingPercentage = "APPINSIGHTSTRACE_SAMPLING_PERCENTAGE"
+	EnvMaxItemsPerSecond  = "APPINSIGHTSTRACE_MAX_ITEMS_PER_SECOND"
+	// Comma separated list of exporters, ex: "appinsights,console"
+	EnvExporters = "APPINSIGHTSTRACE_EXPORTERS"
+)
+
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Loads the options with the following precedence (highest first):
+//   - environment variables (check the Env constants)
+//   - the YAML file at path, or at APPINSIGHTSTRACE_CONFIG_FILE if path is
+//     empty, no file is read if both are empty
+//   - the defaults
+//
+// Setting one of the sampling environment variables (sampling percentage or
+// max items per second) switches the sampling mode, the other sampling option
+// of the YAML file is cleared instead of being reported as combined.
+// Unknown keys in the YAML file are rejected, the loaded options are validated
+// and all of the problems found are returned joined, each wrapping
+// ErrInvalidOptions
+//
+//	exporters: [appinsights, console]
+//	maxBatchSize: 512
+//	maxBatchInterval: 5s
+func LoadOptions(path string) (*AppInsightsOptions, error) {
+	optn := &AppInsightsOptions{
+		Exporters: []string{ExporterAppInsights},
+	}
+
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(EnvConfigFile))
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, optn); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOptions, path, err)
+		}
+	}
+
+	errs := optn.applyEnv(os.LookupEnv)
+	if err := optn.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	return optn, nil
+}
+
+// Overrides the options with the environment variables that are set and not
+// empty, a sampling variable clears the other sampling option unless both are
+// set, returns an error for every variable that could not be parsed
+func (optn *AppInsightsOptions) applyEnv(
+	lookup func(string) (string, bool),
+) []error {
+	env := func(key string) (string, bool) {
+		val, ok := lookup(key)
+		val = strings.TrimSpace(val)
+		return val, ok && val != ""
+	}
+	errs := []error{}
+
+	if val, ok := env(EnvConnectionString); ok {
+		optn.ConnectionString = val
+	}
+	if val, ok := env(EnvInstrumentationKey); ok {
+		optn.InstrumentationKey = val
+	}
+	if val, ok := env(EnvServiceName); ok {
+		optn.ServiceName = val
+	}
+	if val, ok := env(EnvMaxBatchSize); ok {
+		size, err := strconv.Atoi(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%w: %s must be an integer, got %q",
+				ErrInvalidOptions,
+				EnvMaxBatchSize,
+				val,
+			))
+		} else {
+			optn.MaxBatchSize = size
+		}
+	}
+	if val, ok := env(EnvMaxBatchInterval); ok {
+		interval, err := time.ParseDuration(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%w: %s must be a duration (ex: 10s), got %q",
+				ErrInvalidOptions,
+				EnvMaxBatchInterval,
+				val,
+			))
+		} else {
+			optn.MaxBatchInterval = interval
+		}
+	}
+	if val, ok := env(EnvSamplingPercentage); ok {
+		percentage, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%w: %s must be a number, got %q",
+				ErrInvalidOptions,
+				EnvSamplingPercentage,
+				val,
+			))
+		} else {
+			optn.SamplingPercentage = percentage
+			if _, both := env(EnvMaxItemsPerSecond); !both {
+				optn.MaxItemsPerSecond = 0
+			}
+		}
+	}
+	if val, ok := env(EnvMaxItemsPerSecond); ok {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"%w: %s must be a number, got %q",
+				ErrInvalidOptions,
+				EnvMaxItemsPerSecond,
+				val,
+			))
+		} else {
+			optn.MaxItemsPerSecond = rate
+			if _, both := env(EnvSamplingPercentage); !both {
+				optn.SamplingPercentage = 0
+			}
+		}
+	}
+	if val, ok := env(EnvExporters); ok {
+		optn.Exporters = []string{}
+		for _, name := range strings.Split(val, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				optn.Exporters = append(optn.Exporters, name)
+			}
+		}
+	}
+	return errs
+}
+
+// Validates the options, all of the problems found are returned joined, each
+// wrapping ErrInvalidOptions
+func (optn *AppInsightsOptions) Validate() error {
+	errs := []error{}
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(
+			"%w: "+format,
+			append([]interface{}{ErrInvalidOptions}, args...)...,
+		))
+	}
+
+	if optn.MaxBatchSize < 0 {
+		invalid("maxBatchSize must not be negative, got %d", optn.MaxBatchSize)
+	}
+	if optn.MaxBatchInterval < 0 {
+		invalid(
+			"maxBatchInterval must not be negative, got %s",
+			optn.MaxBatchInterval,
+		)
+	}
+	if optn.SamplingPercentage < 0 || optn.SamplingPercentage > 100 ||
+		math.IsNaN(optn.SamplingPercentage) {
+		invalid(
+			"samplingPercentage must be between 0 and 100, got %v",
+			optn.SamplingPercentage,
+		)
+	}
+	if optn.MaxItemsPerSecond < 0 || math.IsNaN(optn.MaxItemsPerSecond) {
+		invalid(
+			"maxItemsPerSecond must not be negative, got %v",
+			optn.MaxItemsPerSecond,
+		)
+	}
+	if optn.MaxItemsPerSecond > 0 && optn.SamplingPercentage > 0 {
+		invalid("samplingPercentage and maxItemsPerSecond can't be combined")
+	}
+	if tail := optn.TailSampling; tail != nil {
+		if tail.Timeout < 0 || tail.LatencyThreshold < 0 {
+			invalid("tailSampling durations must not be negative")
+		}
+		if tail.Percentage > 100 || math.IsNaN(tail.Percentage) {
+			invalid(
+				"tailSampling.percentage must not exceed 100, got %v",
+				tail.Percentage,
+			)
+		}
+		if tail.MaxOperations < 0 || tail.MaxItems < 0 {
+			invalid("tailSampling limits must not be negative")
+		}
+	}
+	if optn.Redaction != nil {
+		if _, err := NewRedactor(optn.Redaction); err != nil {
+			invalid("redaction: %v", err)
+		}
+	}
+	if optn.DependencyData != nil && optn.DependencyData.MaxLength < 0 {
+		invalid(
+			"dependencyData.maxLength must not be negative, got %d",
+			optn.DependencyData.MaxLength,
+		)
+	}
+
+	seen := map[string]bool{}
+	for _, name := range optn.Exporters {
+		switch name {
+		case ExporterAppInsights, ExporterConsole:
+		default:
+			invalid("unknown exporter %q", name)
+		}
+		if seen[name] {
+			invalid("duplicate exporter %q", name)
+		}
+		seen[name] = true
+	}
+
+	if len(optn.Exporters) == 0 || seen[ExporterAppInsights] {
+		if optn.ConnectionString != "" {
+			if _, err := ParseConnectionString(optn.ConnectionString); err != nil {
+				invalid("connectionString: %v", err)
+			}
+		} else if optn.InstrumentationKey == "" {
+			invalid(
+				"connectionString or instrumentationKey is required by the %s exporter",
+				ExporterAppInsights,
+			)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,264 @@
+package appinsightstrace_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadOptionsEnvSwitchesSamplingMode(t *testing.T) {
+	t.Setenv(ait.EnvInstrumentationKey, "ikey")
+
+	t.Run("max items per second", func(t *testing.T) {
+		path := writeConfigFile(t, "samplingPercentage: 25\n")
+		t.Setenv(ait.EnvMaxItemsPerSecond, "10")
+		optn, err := ait.LoadOptions(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if optn.MaxItemsPerSecond != 10 || optn.SamplingPercentage != 0 {
+			t.Errorf(
+				"maxItemsPerSecond %v samplingPercentage %v",
+				optn.MaxItemsPerSecond,
+				optn.SamplingPercentage,
+			)
+		}
+	})
+
+	t.Run("sampling percentage", func(t *testing.T) {
+		path := writeConfigFile(t, "maxItemsPerSecond: 10\n")
+		t.Setenv(ait.EnvSamplingPercentage, "25")
+		optn, err := ait.LoadOptions(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if optn.MaxItemsPerSecond != 0 || optn.SamplingPercentage != 25 {
+			t.Errorf(
+				"maxItemsPerSecond %v samplingPercentage %v",
+				optn.MaxItemsPerSecond,
+				optn.SamplingPercentage,
+			)
+		}
+	})
+
+	t.Run("both", func(t *testing.T) {
+		t.Setenv(ait.EnvSamplingPercentage, "25")
+		t.Setenv(ait.EnvMaxItemsPerSecond, "10")
+		_, err := ait.LoadOptions("")
+		if !errors.Is(err, ait.ErrInvalidOptions) {
+			t.Fatalf("expected invalid options, got %v", err)
+		}
+	})
+}
+
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{
+		ait.EnvConnectionString,
+		ait.EnvInstrumentationKey,
+		ait.EnvServiceName,
+		ait.EnvConfigFile,
+		ait.EnvMaxBatchSize,
+		ait.EnvMaxBatchInterval,
+		ait.EnvSamplingPercentage,
+		ait.EnvMaxItemsPerSecond,
+		ait.EnvExporters,
+	} {
+		// empty variables are ignored by LoadOptions
+		t.Setenv(key, "")
+	}
+}
+
+func TestLoadOptionsRejectsUnknownKeys(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "instrumentationKey: ikey\nmaxBatchSise: 10\n")
+	_, err := ait.LoadOptions(path)
+	if !errors.Is(err, ait.ErrInvalidOptions) {
+		t.Fatalf("expected invalid options, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "maxBatchSise") {
+		t.Errorf("expected the unknown key in %q", err)
+	}
+}
+
+func TestLoadOptionsEnvOverridesFile(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, strings.Join([]string{
+		"instrumentationKey: file-ikey",
+		"serviceName: file-service",
+		"maxBatchSize: 512",
+		"maxBatchInterval: 5s",
+		"exporters: [appinsights, console]",
+		"",
+	}, "\n"))
+	t.Setenv(ait.EnvConfigFile, path)
+	t.Setenv(ait.EnvInstrumentationKey, "env-ikey")
+	t.Setenv(ait.EnvMaxBatchSize, " 64 ")
+	t.Setenv(ait.EnvExporters, "console, ")
+
+	optn, err := ait.LoadOptions("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if optn.InstrumentationKey != "env-ikey" {
+		t.Errorf("instrumentationKey %q", optn.InstrumentationKey)
+	}
+	if optn.MaxBatchSize != 64 {
+		t.Errorf("maxBatchSize %d", optn.MaxBatchSize)
+	}
+	if len(optn.Exporters) != 1 || optn.Exporters[0] != ait.ExporterConsole {
+		t.Errorf("exporters %v", optn.Exporters)
+	}
+	if optn.ServiceName != "file-service" {
+		t.Errorf("serviceName %q", optn.ServiceName)
+	}
+	if optn.MaxBatchInterval != 5*time.Second {
+		t.Errorf("maxBatchInterval %s", optn.MaxBatchInterval)
+	}
+}
+
+func TestLoadOptionsDefaults(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv(ait.EnvConnectionString, "InstrumentationKey=ikey")
+	optn, err := ait.LoadOptions("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(optn.Exporters) != 1 || optn.Exporters[0] != ait.ExporterAppInsights {
+		t.Errorf("exporters %v", optn.Exporters)
+	}
+	if optn.ConnectionString != "InstrumentationKey=ikey" {
+		t.Errorf("connectionString %q", optn.ConnectionString)
+	}
+}
+
+func TestLoadOptionsValidation(t *testing.T) {
+	cases := map[string]struct {
+		file string
+		env  map[string]string
+		want []string
+	}{
+		"missing key": {
+			file: "serviceName: svc\n",
+			want: []string{"connectionString or instrumentationKey is required"},
+		},
+		"console only needs no key": {
+			file: "exporters: [console]\n",
+		},
+		"invalid connection string": {
+			file: "connectionString: IngestionEndpoint=https://example.com\n",
+			want: []string{"connectionString"},
+		},
+		"negative values": {
+			file: strings.Join([]string{
+				"instrumentationKey: ikey",
+				"maxBatchSize: -1",
+				"maxBatchInterval: -1s",
+				"",
+			}, "\n"),
+			want: []string{"maxBatchSize", "maxBatchInterval"},
+		},
+		"sampling out of range": {
+			file: "instrumentationKey: ikey\nsamplingPercentage: 101\n",
+			want: []string{"samplingPercentage must be between 0 and 100"},
+		},
+		"combined sampling": {
+			file: strings.Join([]string{
+				"instrumentationKey: ikey",
+				"samplingPercentage: 10",
+				"maxItemsPerSecond: 5",
+				"",
+			}, "\n"),
+			want: []string{"can't be combined"},
+		},
+		"exporters": {
+			file: "exporters: [console, console, stdout]\n",
+			want: []string{
+				`unknown exporter "stdout"`,
+				`duplicate exporter "console"`,
+			},
+		},
+		"unparseable env": {
+			file: "instrumentationKey: ikey\n",
+			env: map[string]string{
+				ait.EnvMaxBatchSize:       "lots",
+				ait.EnvMaxBatchInterval:   "5",
+				ait.EnvSamplingPercentage: "half",
+			},
+			want: []string{
+				ait.EnvMaxBatchSize,
+				ait.EnvMaxBatchInterval,
+				ait.EnvSamplingPercentage,
+			},
+		},
+		"env and file problems": {
+			file: "exporters: [stdout]\n",
+			env:  map[string]string{ait.EnvMaxItemsPerSecond: "many"},
+			want: []string{ait.EnvMaxItemsPerSecond, `unknown exporter "stdout"`},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for key, val := range tc.env {
+				t.Setenv(key, val)
+			}
+			optn, err := ait.LoadOptions(writeConfigFile(t, tc.file))
+			if len(tc.want) == 0 {
+				if err != nil || optn == nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if optn != nil {
+				t.Errorf("expected no options with an error")
+			}
+			if !errors.Is(err, ait.ErrInvalidOptions) {
+				t.Fatalf("expected invalid options, got %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in %q", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateWrapsEveryProblem(t *testing.T) {
+	optn := &ait.AppInsightsOptions{
+		InstrumentationKey: "ikey",
+		MaxBatchSize:       -1,
+		SamplingPercentage: 200,
+		Exporters:          []string{"stdout"},
+	}
+	err := optn.Validate()
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected joined errors, got %v", err)
+	}
+	if errs := joined.Unwrap(); len(errs) != 3 {
+		t.Fatalf("expected 3 problems, got %d: %v", len(errs), err)
+	} else {
+		for _, err := range errs {
+			if !errors.Is(err, ait.ErrInvalidOptions) {
+				t.Errorf("%q doesn't wrap ErrInvalidOptions", err)
+			}
+		}
+	}
+}
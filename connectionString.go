@@ -0,0 +1,166 @@
+package appinsightstrace
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+)
+
+const (
+	// Ingestion endpoint used when neither IngestionEndpoint nor EndpointSuffix
+	// is provided in the connection string
+	DefaultIngestionEndpoint = "https://dc.services.visualstudio.com"
+	// Live metrics endpoint used when neither LiveEndpoint nor EndpointSuffix is
+	// provided in the connection string
+	DefaultLiveEndpoint = "https://rt.services.visualstudio.com"
+
+	maxConnectionStringLength = 4096
+	trackPath                 = "/v2/track"
+)
+
+var ErrInvalidConnectionString = errors.New("invalid connection string")
+
+// Parsed Application Insights connection string
+// (https://learn.microsoft.com/azure/azure-monitor/app/sdk-connection-string),
+// endpoints are resolved with the documented precedence: explicit endpoints
+// first, then endpoints built from EndpointSuffix (and Location), then the
+// public cloud defaults
+type ConnectionString struct {
+	InstrumentationKey string
+	IngestionEndpoint  string
+	LiveEndpoint       string
+	AadAudience        string
+	EndpointSuffix     string
+	Location           string
+	Authorization      string
+}
+
+// Parses a connection string in the key1=value1;key2=value2 format, keys are
+// case insensitive and InstrumentationKey is required
+func ParseConnectionString(connectionString string) (*ConnectionString, error) {
+	connectionString = strings.TrimSpace(connectionString)
+	if connectionString == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidConnectionString)
+	}
+	if len(connectionString) > maxConnectionStringLength {
+		return nil, fmt.Errorf(
+			"%w: exceeds %d characters",
+			ErrInvalidConnectionString,
+			maxConnectionStringLength,
+		)
+	}
+
+	values := map[string]string{}
+	for _, pair := range strings.Split(connectionString, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idx := strings.IndexByte(pair, '=')
+		if idx <= 0 {
+			return nil, fmt.Errorf(
+				"%w: malformed segment %q",
+				ErrInvalidConnectionString,
+				pair,
+			)
+		}
+		key := strings.ToLower(strings.TrimSpace(pair[:idx]))
+		value := strings.TrimSpace(pair[idx+1:])
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf(
+				"%w: duplicate key %q",
+				ErrInvalidConnectionString,
+				pair[:idx],
+			)
+		}
+		values[key] = value
+	}
+
+	cs := &ConnectionString{
+		InstrumentationKey: values["instrumentationkey"],
+		AadAudience:        values["aadaudience"],
+		EndpointSuffix:     strings.Trim(values["endpointsuffix"], "./"),
+		Location:           strings.Trim(values["location"], "./"),
+		Authorization:      values["authorization"],
+	}
+	if cs.InstrumentationKey == "" {
+		return nil, fmt.Errorf(
+			"%w: missing InstrumentationKey",
+			ErrInvalidConnectionString,
+		)
+	}
+	if cs.Authorization != "" && !strings.EqualFold(cs.Authorization, "ikey") {
+		return nil, fmt.Errorf(
+			"%w: unsupported Authorization %q",
+			ErrInvalidConnectionString,
+			cs.Authorization,
+		)
+	}
+
+	var err error
+	if cs.IngestionEndpoint, err = resolveEndpoint(
+		values["ingestionendpoint"],
+		"dc",
+		cs,
+		DefaultIngestionEndpoint,
+	); err != nil {
+		return nil, err
+	}
+	if cs.LiveEndpoint, err = resolveEndpoint(
+		values["liveendpoint"],
+		"live",
+		cs,
+		DefaultLiveEndpoint,
+	); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Returns the url telemetry should be submitted to
+func (cs *ConnectionString) TrackEndpoint() string {
+	return cs.IngestionEndpoint + trackPath
+}
+
+// Builds the configuration for an Application Insights telemetry client
+// submitting to the ingestion endpoint of the connection string
+func (cs *ConnectionString) TelemetryConfiguration() *appinsights.TelemetryConfiguration {
+	config := appinsights.NewTelemetryConfiguration(cs.InstrumentationKey)
+	config.EndpointUrl = cs.TrackEndpoint()
+	return config
+}
+
+func resolveEndpoint(
+	explicit string,
+	prefix string,
+	cs *ConnectionString,
+	fallback string,
+) (string, error) {
+	endpoint := fallback
+	if explicit != "" {
+		endpoint = explicit
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+	} else if cs.EndpointSuffix != "" {
+		host := prefix + "." + cs.EndpointSuffix
+		if cs.Location != "" {
+			host = cs.Location + "." + host
+		}
+		endpoint = "https://" + host
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf(
+			"%w: invalid endpoint %q",
+			ErrInvalidConnectionString,
+			endpoint,
+		)
+	}
+	return endpoint, nil
+}
@@ -0,0 +1,95 @@
+package appinsightstrace_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+const testIkey = "00000000-0000-0000-0000-000000000000"
+
+func TestParseConnectionString(t *testing.T) {
+	cases := map[string]ait.ConnectionString{
+		"InstrumentationKey=" + testIkey: {
+			InstrumentationKey: testIkey,
+			IngestionEndpoint:  ait.DefaultIngestionEndpoint,
+			LiveEndpoint:       ait.DefaultLiveEndpoint,
+		},
+		"instrumentationkey=" + testIkey + ";ENDPOINTSUFFIX=ai.contoso.com": {
+			InstrumentationKey: testIkey,
+			IngestionEndpoint:  "https://dc.ai.contoso.com",
+			LiveEndpoint:       "https://live.ai.contoso.com",
+			EndpointSuffix:     "ai.contoso.com",
+		},
+		"InstrumentationKey=" + testIkey + ";EndpointSuffix=.ai.contoso.com/;Location=westus2": {
+			InstrumentationKey: testIkey,
+			IngestionEndpoint:  "https://westus2.dc.ai.contoso.com",
+			LiveEndpoint:       "https://westus2.live.ai.contoso.com",
+			EndpointSuffix:     "ai.contoso.com",
+			Location:           "westus2",
+		},
+		// explicit endpoints override the suffix
+		"InstrumentationKey=" + testIkey + ";EndpointSuffix=ai.contoso.com;IngestionEndpoint=https://custom.com:444/": {
+			InstrumentationKey: testIkey,
+			IngestionEndpoint:  "https://custom.com:444",
+			LiveEndpoint:       "https://live.ai.contoso.com",
+			EndpointSuffix:     "ai.contoso.com",
+		},
+		" InstrumentationKey = " + testIkey + " ; LiveEndpoint=live.custom.com;;AadAudience=https://monitor.azure.com/;Authorization=ikey;": {
+			InstrumentationKey: testIkey,
+			IngestionEndpoint:  ait.DefaultIngestionEndpoint,
+			LiveEndpoint:       "https://live.custom.com",
+			AadAudience:        "https://monitor.azure.com/",
+			Authorization:      "ikey",
+		},
+	}
+	for connectionString, expected := range cases {
+		cs, err := ait.ParseConnectionString(connectionString)
+		if err != nil {
+			t.Errorf("ParseConnectionString(%q) unexpected error: %v", connectionString, err)
+			continue
+		}
+		if fmt.Sprint(*cs) != fmt.Sprint(expected) {
+			t.Errorf("ParseConnectionString(%q) = %+v, expected %+v", connectionString, *cs, expected)
+		}
+	}
+}
+
+func TestParseConnectionStringErrors(t *testing.T) {
+	cases := map[string]string{
+		"empty":                  " ",
+		"missing ikey":           "IngestionEndpoint=https://dc.com",
+		"empty ikey":             "InstrumentationKey=;EndpointSuffix=ai.contoso.com",
+		"missing =":              "InstrumentationKey=" + testIkey + ";EndpointSuffix",
+		"missing key":            "=value;InstrumentationKey=" + testIkey,
+		"duplicate key":          "InstrumentationKey=" + testIkey + ";instrumentationKey=" + testIkey,
+		"unsupported auth":       "InstrumentationKey=" + testIkey + ";Authorization=aad",
+		"invalid endpoint":       "InstrumentationKey=" + testIkey + ";IngestionEndpoint=https://",
+		"exceeds maximum length": "InstrumentationKey=" + testIkey + ";AadAudience=" + strings.Repeat("a", 4096),
+	}
+	for name, connectionString := range cases {
+		if cs, err := ait.ParseConnectionString(connectionString); !errors.Is(err, ait.ErrInvalidConnectionString) {
+			t.Errorf("%s: ParseConnectionString = %+v, %v, expected ErrInvalidConnectionString", name, cs, err)
+		}
+	}
+}
+
+func TestConnectionStringTelemetryConfiguration(t *testing.T) {
+	cs, err := ait.ParseConnectionString(
+		"InstrumentationKey=" + testIkey + ";IngestionEndpoint=https://westus2-1.in.applicationinsights.azure.com/",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := cs.TelemetryConfiguration()
+	expected := "https://westus2-1.in.applicationinsights.azure.com/v2/track"
+	if cs.TrackEndpoint() != expected || config.EndpointUrl != expected {
+		t.Errorf("endpoint %q, expected %q", config.EndpointUrl, expected)
+	}
+	if config.InstrumentationKey != testIkey {
+		t.Errorf("instrumentation key %q, expected %q", config.InstrumentationKey, testIkey)
+	}
+}
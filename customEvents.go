@@ -0,0 +1,66 @@
+package appinsightstrace
+
+import (
+	"context"
+	"time"
+)
+
+// - Context dependent
+
+// Transmits a new custom event telemetry (customEvents table), this should be
+// used to track business events such as "OrderPlaced". Unlike TraceEvent,
+// which traces the consumption of a message as a request, this does not
+// appear in the requests table
+//
+// ctx: the current context of the execution, the ITraceExtractor.ExtractTraceInfo
+//
+//	function will be utilized to extract traceId and current requestId from
+//	the context the default implementation of ITraceExtractor provided in
+//	this package will leave these fields empty
+//
+// name: the name of the event
+// fields: additional custom values to include in the telemetry
+// measurements: custom numeric values to include in the telemetry
+func (ins *AppInsightsCore) TrackCustomEvent(
+	ctx context.Context,
+	name string,
+	fields map[string]string,
+	measurements map[string]float64,
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	ins.TrackCustomEventWithIds(tid, rid, name, fields, measurements)
+}
+
+// - Context Independent
+
+// Transmits a new custom event telemetry (customEvents table). It uses the
+// provied traceId and requestId instead of trying to extract the same from
+// the context.
+//
+// traceId: is a common identifier for all dependents and dependencies for the
+//
+//	request, can be left as an empty string but this would reduce tracablility
+//
+// requestId is the unique Id for the request, generated at the start of the
+//
+//	request, can be left as an empty string but this would reduce tracablility
+//
+// name: the name of the event
+// fields: additional custom values to include in the telemetry
+// measurements: custom numeric values to include in the telemetry
+func (ins *AppInsightsCore) TrackCustomEventWithIds(
+	traceId string,
+	requestId string,
+	name string,
+	fields map[string]string,
+	measurements map[string]float64,
+) {
+	tele := &EventTelemetry{
+		Name:          name,
+		TelemetryBase: ins.newTelemetryBase(time.Now(), traceId, requestId, fields),
+	}
+	for key, val := range measurements {
+		tele.Measurements[key] = val
+	}
+	ins.track(tele)
+}
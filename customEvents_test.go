@@ -0,0 +1,50 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+func TestTrackCustomEvent(t *testing.T) {
+	core, rec := appinsightstest.NewCore("events-test")
+	tid, sid := ait.NewTraceId(), ait.NewSpanId()
+	ctx := ait.ContextWithTraceInfo(
+		context.Background(),
+		ait.TraceInfo{TraceId: tid, SpanId: sid},
+	)
+	fields := map[string]string{"orderId": "42"}
+	measurements := map[string]float64{"total": 99.5}
+
+	core.TrackCustomEvent(ctx, "OrderPlaced", fields, measurements)
+	core.TrackCustomEventWithIds("trace", "request", "OrderShipped", nil, nil)
+
+	rec.RequireCount(t, 2)
+	placed := rec.RequireEvent(t, "OrderPlaced")
+	if placed.OperationId != tid || placed.ParentId != sid || placed.CloudRole != "events-test" {
+		t.Errorf("unexpected event base %+v", placed.TelemetryBase)
+	}
+	if placed.Properties["orderId"] != "42" || placed.Measurements["total"] != 99.5 {
+		t.Errorf("unexpected event data %v %v", placed.Properties, placed.Measurements)
+	}
+	if placed.Timestamp.IsZero() {
+		t.Errorf("event not timestamped")
+	}
+	if len(rec.Requests()) != 0 {
+		t.Errorf("custom event traced as a request")
+	}
+
+	shipped := rec.RequireEvent(t, "OrderShipped")
+	if shipped.OperationId != "trace" || shipped.ParentId != "request" {
+		t.Errorf("unexpected event ids %s %s", shipped.OperationId, shipped.ParentId)
+	}
+
+	// the caller's maps are not retained
+	fields["orderId"] = "43"
+	measurements["total"] = 0
+	if placed.Properties["orderId"] != "42" || placed.Measurements["total"] != 99.5 {
+		t.Errorf("event shares the caller maps")
+	}
+}
@@ -0,0 +1,226 @@
+package appinsightstrace
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Default dependency types whose data is sanitized as SQL, compared case
+// insensitive
+var DefaultSqlDependencyTypes = []string{
+	"SQL",
+	"postgres",
+	"postgresql",
+	"mysql",
+	"mariadb",
+	"mssql",
+	"sqlserver",
+	"sqlite",
+	"oracle",
+	"cockroachdb",
+}
+
+// Default dependency types whose double quoted text is sanitized as a string
+// literal instead of being kept as an identifier, compared case insensitive
+var DefaultDoubleQuotedSqlTypes = []string{
+	"mysql",
+	"mariadb",
+}
+
+// Options for the dependency data processor
+//
+// MaxLength: the maximum length in bytes of the data (command) of
+//
+//	dependencies, longer data is truncated, zero disables the truncation
+//
+// SanitizeSql: whether literals in the data of SQL dependencies should be
+//
+//	replaced with "?", check SanitizeSql
+//
+// SqlTypes: the dependency types sanitized as SQL, defaults to
+//
+//	DefaultSqlDependencyTypes
+//
+// DoubleQuotedTypes: the SQL dependency types where double quoted text is a
+//
+//	string literal (ex: MySQL without ANSI_QUOTES) rather than an
+//	identifier, defaults to DefaultDoubleQuotedSqlTypes
+type DependencyDataOptions struct {
+	MaxLength         int      `yaml:"maxLength"`
+	SanitizeSql       bool     `yaml:"sanitizeSql"`
+	SqlTypes          []string `yaml:"sqlTypes"`
+	DoubleQuotedTypes []string `yaml:"doubleQuotedTypes"`
+}
+
+// Constructs a processor sanitizing and truncating the data (command) of the
+// dependency telemetry, so SQL parameter values don't leak and large payloads
+// are not sent
+func NewDependencyDataProcessor(
+	optn *DependencyDataOptions,
+) ITelemetryProcessor {
+	if optn == nil {
+		optn = &DependencyDataOptions{}
+	}
+	sqlTypesList := optn.SqlTypes
+	if len(sqlTypesList) == 0 {
+		sqlTypesList = DefaultSqlDependencyTypes
+	}
+	doubleQuotedList := optn.DoubleQuotedTypes
+	if len(doubleQuotedList) == 0 {
+		doubleQuotedList = DefaultDoubleQuotedSqlTypes
+	}
+	sqlTypes := make(map[string]bool, len(sqlTypesList))
+	for _, depType := range sqlTypesList {
+		sqlTypes[strings.ToLower(depType)] = false
+	}
+	for _, depType := range doubleQuotedList {
+		if _, isSql := sqlTypes[strings.ToLower(depType)]; isSql {
+			sqlTypes[strings.ToLower(depType)] = true
+		}
+	}
+	maxLength := optn.MaxLength
+	sanitize := optn.SanitizeSql
+
+	return TelemetryProcessorFunc(func(item ITelemetry) bool {
+		dep, ok := item.(*DependencyTelemetry)
+		if !ok || dep.Data == "" {
+			return true
+		}
+		doubleQuoted, isSql := sqlTypes[strings.ToLower(dep.Type)]
+		if isSql && sanitize {
+			dep.Data = sanitizeSql(dep.Data, doubleQuoted)
+		}
+		if maxLength > 0 {
+			dep.Data = truncateString(dep.Data, maxLength)
+		}
+		return true
+	})
+}
+
+// Replaces the string (including dollar quoted) and numeric literals of the
+// SQL statement with "?", identifiers (including double quoted),
+// placeholders ($1, @p1, :name) and comments are left as is. Double quoted
+// text is kept as an identifier, the dependency data processor sanitizes it
+// as a string literal for the DoubleQuotedTypes (MySQL). Backslash escaped
+// quotes are not supported
+//
+//	SELECT * FROM users WHERE email = 'a@b.com' AND age > 30
+//	SELECT * FROM users WHERE email = ? AND age > ?
+func SanitizeSql(statement string) string {
+	return sanitizeSql(statement, false)
+}
+
+func sanitizeSql(statement string, doubleQuotedStrings bool) string {
+	bldr := strings.Builder{}
+	bldr.Grow(len(statement))
+	prev := byte(' ')
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == '-' && strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement) - i
+			}
+			bldr.WriteString(statement[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				end = len(statement) - i
+			} else {
+				end += 4
+			}
+			bldr.WriteString(statement[i : i+end])
+			i += end
+		case c == '\'':
+			i += quotedLength(statement[i:], c)
+			bldr.WriteByte('?')
+		case c == '"':
+			end := i + quotedLength(statement[i:], c)
+			if doubleQuotedStrings {
+				bldr.WriteByte('?')
+			} else {
+				bldr.WriteString(statement[i:end])
+			}
+			i = end
+		case c == '$' && !isSqlIdentifier(prev) && dollarQuoteTag(statement[i:]) != "":
+			// dollar quoted string literal, ex: $$text$$ or $tag$text$tag$
+			tag := dollarQuoteTag(statement[i:])
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end < 0 {
+				i = len(statement)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			bldr.WriteByte('?')
+		case isSqlDigit(c) && !isSqlIdentifier(prev):
+			for i < len(statement) &&
+				(isSqlIdentifier(statement[i]) || statement[i] == '.') {
+				i++
+			}
+			bldr.WriteByte('?')
+		default:
+			bldr.WriteByte(c)
+			i++
+		}
+		prev = statement[i-1]
+	}
+	return bldr.String()
+}
+
+// Gets the length of the text quoted by the quote at the start of the
+// statement, quotes are escaped by doubling them
+func quotedLength(statement string, quote byte) int {
+	i := 1
+	for i < len(statement) {
+		if statement[i] == quote {
+			if i+1 < len(statement) && statement[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+// Gets the dollar quote delimiter ($$ or $tag$) at the start of the
+// statement, empty if there is none (ex: a $1 placeholder)
+func dollarQuoteTag(statement string) string {
+	for i := 1; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '$':
+			return statement[:i+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			c >= utf8.RuneSelf:
+		case isSqlDigit(c) && i > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isSqlDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSqlIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || c == ':' || c == '#' ||
+		isSqlDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		c >= utf8.RuneSelf
+}
+
+// Truncates the string to at most maxLength bytes without splitting a rune
+func truncateString(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+	for maxLength > 0 && !utf8.RuneStart(value[maxLength]) {
+		maxLength--
+	}
+	return value[:maxLength]
+}
@@ -0,0 +1,42 @@
+package appinsightstrace_test
+
+import (
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+func TestSanitizeSql(t *testing.T) {
+	cases := map[string]string{
+		"SELECT * FROM users WHERE email = 'a@b.com' AND age > 30": "SELECT * FROM users WHERE email = ? AND age > ?",
+		"SELECT 'it''s', t1.col2 FROM t1 WHERE id = $1":            "SELECT ?, t1.col2 FROM t1 WHERE id = $1",
+		"SELECT $$secret$$, $tag$it's $$ secret$tag$ FROM t":       "SELECT ?, ? FROM t",
+		"SELECT $body$unterminated":                                "SELECT ?",
+		`SELECT "it's" FROM t WHERE "a" = @p1 -- 'comment'`:        `SELECT "it's" FROM t WHERE "a" = @p1 -- 'comment'`,
+	}
+	for statement, expected := range cases {
+		if sanitized := ait.SanitizeSql(statement); sanitized != expected {
+			t.Errorf("SanitizeSql(%q) = %q, expected %q", statement, sanitized, expected)
+		}
+	}
+}
+
+func TestDependencyDataProcessorDoubleQuotes(t *testing.T) {
+	proc := ait.NewDependencyDataProcessor(&ait.DependencyDataOptions{
+		SanitizeSql: true,
+	})
+	cases := map[string]string{
+		"MySQL":      `SELECT ? FROM t WHERE name = ?`,
+		"PostgreSQL": `SELECT "col" FROM t WHERE name = ?`,
+	}
+	for depType, expected := range cases {
+		dep := &ait.DependencyTelemetry{
+			Type: depType,
+			Data: `SELECT "col" FROM t WHERE name = 'secret'`,
+		}
+		proc.Process(dep)
+		if dep.Data != expected {
+			t.Errorf("%s data %q, expected %q", depType, dep.Data, expected)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package appinsightstrace
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	pkgerrors "github.com/pkg/errors"
+)
+
+const (
+	// Maximum number of inner exceptions recorded per exception telemetry
+	maxInnerExceptions = 32
+	// Maximum depth of the error chain walked
+	maxErrorChainDepth = 64
+)
+
+// An error wrapped by the error of an exception telemetry, the outer error of
+// the telemetry has the Id 0 and OuterId links the inner exception to the
+// error wrapping it
+type ExceptionDetails struct {
+	Id       int
+	OuterId  int
+	TypeName string
+	Message  string
+	Frames   []*contracts.StackFrame
+}
+
+// Walks the errors wrapped by err (errors.Unwrap, errors.Join and
+// github.com/pkg/errors Cause chains) and returns them as inner exceptions
+// along with the stack recorded for err, if any. Wrappers that do not change
+// the message (ex: pkg/errors.WithStack) are merged into the error they wrap
+func errorChain(err error) ([]*contracts.StackFrame, []*ExceptionDetails) {
+	outer := &ExceptionDetails{
+		Message: err.Error(),
+		Frames:  errorStackFrames(err),
+	}
+	inner := []*ExceptionDetails{}
+	walkErrorChain(err, outer, &inner, 0)
+	return outer.Frames, inner
+}
+
+func walkErrorChain(
+	err error,
+	outer *ExceptionDetails,
+	inner *[]*ExceptionDetails,
+	depth int,
+) {
+	if depth >= maxErrorChainDepth {
+		return
+	}
+	children := unwrapErrors(err)
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if len(*inner) >= maxInnerExceptions {
+			return
+		}
+		message := child.Error()
+		frames := errorStackFrames(child)
+		if len(children) == 1 && message == outer.Message {
+			if outer.Frames == nil {
+				outer.Frames = frames
+			}
+			walkErrorChain(child, outer, inner, depth+1)
+			continue
+		}
+		details := &ExceptionDetails{
+			Id:       len(*inner) + 1,
+			OuterId:  outer.Id,
+			TypeName: reflect.TypeOf(child).String(),
+			Message:  message,
+			Frames:   frames,
+		}
+		*inner = append(*inner, details)
+		walkErrorChain(child, details, inner, depth+1)
+	}
+}
+
+func unwrapErrors(err error) []error {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	case interface{ Unwrap() error }:
+		return []error{e.Unwrap()}
+	case interface{ Cause() error }:
+		return []error{e.Cause()}
+	default:
+		return nil
+	}
+}
+
+// Gets the stack recorded by github.com/pkg/errors for the error, nil if it
+// has none
+func errorStackFrames(err error) []*contracts.StackFrame {
+	tracer, ok := err.(interface{ StackTrace() pkgerrors.StackTrace })
+	if !ok {
+		return nil
+	}
+	trace := tracer.StackTrace()
+	if len(trace) == 0 {
+		return nil
+	}
+	pcs := make([]uintptr, len(trace))
+	for i, frame := range trace {
+		pcs[i] = uintptr(frame)
+	}
+	return callersStackFrames(pcs)
+}
+
+// Converts the program counters (as returned by runtime.Callers) into stack
+// frames formatted the same way as appinsights.GetCallstack
+func callersStackFrames(pcs []uintptr) []*contracts.StackFrame {
+	stackFrames := []*contracts.StackFrame{}
+	frames := runtime.CallersFrames(pcs)
+	for level := 0; ; level++ {
+		frame, more := frames.Next()
+		stackFrame := &contracts.StackFrame{
+			Level:    level,
+			FileName: frame.File,
+			Line:     frame.Line,
+			Method:   frame.Function,
+		}
+		if frame.Function != "" {
+			lastSlash := strings.LastIndexByte(frame.Function, '/')
+			if lastSlash < 0 {
+				lastSlash = 0
+			}
+			if firstDot := strings.IndexByte(frame.Function[lastSlash:], '.'); firstDot >= 0 {
+				stackFrame.Assembly = frame.Function[:lastSlash+firstDot]
+				stackFrame.Method = frame.Function[lastSlash+firstDot+1:]
+			}
+		}
+		stackFrames = append(stackFrames, stackFrame)
+		if !more {
+			break
+		}
+	}
+	return stackFrames
+}
@@ -0,0 +1,187 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// Error exposing its cause only through the Cause method of pkg/errors
+type causeError struct {
+	cause error
+}
+
+func (err causeError) Error() string { return "caused: " + err.cause.Error() }
+func (err causeError) Cause() error  { return err.cause }
+
+// Traces the error and gets the recorded exception telemetry
+func traceChain(t *testing.T, err error) *ait.ExceptionTelemetry {
+	t.Helper()
+	core, rec := appinsightstest.NewCore("chain-test")
+	core.TraceExceptionWithIds(ait.NewTraceId(), ait.NewSpanId(), err, 0, nil)
+	return rec.RequireException(t)
+}
+
+// Formats the inner exceptions as "id>outerId message" lines
+func describeInner(inner []*ait.ExceptionDetails) string {
+	lines := make([]string, len(inner))
+	for i, details := range inner {
+		lines[i] = fmt.Sprintf("%d>%d %s", details.Id, details.OuterId, details.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestErrorChainInnerExceptions(t *testing.T) {
+	notFound := errors.New("not found")
+	timeout := errors.New("timeout")
+	cases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"unwrap": {
+			err:      fmt.Errorf("loading user: %w", fmt.Errorf("query: %w", notFound)),
+			expected: "1>0 query: not found\n2>1 not found",
+		},
+		"join siblings": {
+			err:      fmt.Errorf("saving: %w", errors.Join(notFound, timeout)),
+			expected: "1>0 not found\ntimeout\n2>1 not found\n3>1 timeout",
+		},
+		"cause": {
+			err:      causeError{cause: notFound},
+			expected: "1>0 not found",
+		},
+		"pkg errors": {
+			err:      pkgerrors.Wrap(pkgerrors.WithStack(notFound), "query"),
+			expected: "1>0 not found",
+		},
+		"no chain": {
+			err:      notFound,
+			expected: "",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tele := traceChain(t, c.err)
+			if inner := describeInner(tele.InnerExceptions); inner != c.expected {
+				t.Errorf("inner exceptions\n%s\nexpected\n%s", inner, c.expected)
+			}
+		})
+	}
+}
+
+func TestErrorChainTypeNames(t *testing.T) {
+	tele := traceChain(t, fmt.Errorf("wrapped: %w", causeError{cause: errors.New("x")}))
+	if len(tele.InnerExceptions) != 2 {
+		t.Fatalf("unexpected inner exceptions\n%s", describeInner(tele.InnerExceptions))
+	}
+	if name := tele.InnerExceptions[0].TypeName; name != "appinsightstrace_test.causeError" {
+		t.Errorf("type name %q, expected appinsightstrace_test.causeError", name)
+	}
+	if name := tele.InnerExceptions[1].TypeName; name != "*errors.errorString" {
+		t.Errorf("type name %q, expected *errors.errorString", name)
+	}
+}
+
+func TestErrorChainPkgErrorsStack(t *testing.T) {
+	// WithStack does not change the message and is merged into the Wrap
+	// above it, the stack of the outer error is the stack of the Wrap
+	tele := traceChain(t, pkgerrors.Wrap(pkgerrors.WithStack(errors.New("x")), "query"))
+	if len(tele.Frames) == 0 ||
+		!strings.HasSuffix(tele.Frames[0].Method, "TestErrorChainPkgErrorsStack") {
+		t.Fatalf("expected the stack recorded by pkg/errors, got %+v", tele.Frames)
+	}
+	if len(tele.InnerExceptions) != 1 {
+		t.Fatalf("unexpected inner exceptions\n%s", describeInner(tele.InnerExceptions))
+	}
+	if inner := tele.InnerExceptions[0]; len(inner.Frames) == 0 ||
+		!strings.HasSuffix(inner.Frames[0].Method, "TestErrorChainPkgErrorsStack") {
+		t.Errorf("expected the stack of WithStack on the inner exception")
+	}
+
+	// the stack of the caller is used without a recorded stack
+	tele = traceChain(t, errors.New("x"))
+	if len(tele.Frames) == 0 || !strings.HasSuffix(tele.Frames[0].Method, "traceChain") {
+		t.Errorf("expected the stack of the caller, got %+v", tele.Frames)
+	}
+}
+
+func TestErrorChainCap(t *testing.T) {
+	err := errors.New("root")
+	for i := 0; i < 100; i++ {
+		err = fmt.Errorf("wrap %d: %w", i, err)
+	}
+	tele := traceChain(t, err)
+	if len(tele.InnerExceptions) != 32 {
+		t.Fatalf("expected 32 inner exceptions, got %d", len(tele.InnerExceptions))
+	}
+	for i, inner := range tele.InnerExceptions {
+		if inner.Id != i+1 || inner.OuterId != i {
+			t.Fatalf("unexpected link %d>%d at %d", inner.Id, inner.OuterId, i)
+		}
+	}
+}
+
+// Telemetry client capturing the telemetry tracked
+type capturingClient struct {
+	appinsights.TelemetryClient
+	tracked []appinsights.Telemetry
+}
+
+func (client *capturingClient) Track(tele appinsights.Telemetry) {
+	client.tracked = append(client.tracked, tele)
+}
+
+func TestExceptionChainExport(t *testing.T) {
+	client := &capturingClient{}
+	rec := appinsightstest.NewRecorder()
+	core := ait.NewAppInsightsCoreWithExporter(
+		"chain-test",
+		ait.NewMultiExporter(rec, ait.NewAppInsightsExporter(client)),
+		nil,
+	)
+	core.AddProcessor(ait.TelemetryProcessorFunc(func(item ait.ITelemetry) bool {
+		if tele, ok := item.(*ait.ExceptionTelemetry); ok {
+			tele.Message = "redacted"
+		}
+		return true
+	}))
+
+	core.TraceExceptionInfo(context.Background(), ait.ExceptionInfo{
+		Error:     fmt.Errorf("saving: %w", errors.New("not found")),
+		ProblemId: "save-failed",
+	})
+	tele := rec.RequireException(t)
+	if tele.ProblemId != "save-failed" || tele.Message != "redacted" {
+		t.Errorf("unexpected exception %+v", tele)
+	}
+	if len(client.tracked) != 1 {
+		t.Fatalf("expected 1 tracked telemetry, got %d", len(client.tracked))
+	}
+
+	data, ok := client.tracked[0].TelemetryData().(*contracts.ExceptionData)
+	if !ok {
+		t.Fatalf("unexpected telemetry data %T", client.tracked[0].TelemetryData())
+	}
+	if data.ProblemId != "save-failed" {
+		t.Errorf("problem id %q, expected save-failed", data.ProblemId)
+	}
+	if len(data.Exceptions) != 2 {
+		t.Fatalf("expected the outer and 1 inner exception, got %d", len(data.Exceptions))
+	}
+	outer, inner := data.Exceptions[0], data.Exceptions[1]
+	if outer.Message != "redacted" || len(outer.ParsedStack) == 0 {
+		t.Errorf("unexpected outer exception %+v", outer)
+	}
+	if inner.Id != 1 || inner.OuterId != 0 || inner.Message != "not found" ||
+		inner.TypeName != "*errors.errorString" || inner.HasFullStack {
+		t.Errorf("unexpected inner exception %+v", inner)
+	}
+}
@@ -0,0 +1,363 @@
+package appinsightstrace
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Implement this to send the telemetry produced by AppInsightsCore to a
+// backend, the Application Insights exporter is used by default but any
+// implementation (console, file, OTLP, test recorders) can be provided with
+// NewAppInsightsCoreWithExporter, or several combined with MultiExporter
+type ITelemetryExporter interface {
+	// Queues a single telemetry item to be sent, must be safe to be called
+	// concurrently
+	Export(item ITelemetry)
+
+	// Forces any queued telemetry to be sent
+	Flush()
+
+	// Sends any queued telemetry and releases the resources of the exporter,
+	// blocking until done or timed out
+	Close()
+}
+
+// - Application Insights
+
+// ITelemetryExporter that converts the telemetry into the Application Insights
+// SDK telemetry and tracks it with the TelemetryClient
+type AppInsightsExporter struct {
+	Client appinsights.TelemetryClient
+}
+
+var _ ITelemetryExporter = (*AppInsightsExporter)(nil)
+
+// Constructs an AppInsightsExporter tracking the telemetry with the client
+func NewAppInsightsExporter(
+	client appinsights.TelemetryClient,
+) *AppInsightsExporter {
+	return &AppInsightsExporter{
+		Client: client,
+	}
+}
+
+func (exp *AppInsightsExporter) Export(item ITelemetry) {
+	tele := toAppInsightsTelemetry(item)
+	if tele == nil {
+		return
+	}
+	rate := item.Base().SampleRate
+	if rate <= 0 || rate >= 100 {
+		exp.Client.Track(tele)
+		return
+	}
+	// the client always envelops with a sample rate of 100
+	if exp.Client.IsEnabled() {
+		envelope := envelop(exp.Client.Context(), tele)
+		envelope.SampleRate = rate
+		exp.Client.Channel().Send(envelope)
+	}
+}
+
+func (exp *AppInsightsExporter) Flush() {
+	exp.Client.Channel().Flush()
+}
+
+func (exp *AppInsightsExporter) Close() {
+	select {
+	case <-exp.Client.Channel().Close(10 * time.Second):
+	case <-time.After(30 * time.Second):
+	}
+}
+
+// Converts the telemetry into the matching Application Insights SDK
+// telemetry, nil is returned for unknown implementations of ITelemetry
+func toAppInsightsTelemetry(item ITelemetry) appinsights.Telemetry {
+	base := item.Base()
+	baseTele := appinsights.BaseTelemetry{
+		Timestamp:  base.Timestamp,
+		Tags:       toContextTags(base),
+		Properties: base.Properties,
+	}
+	measurements := appinsights.BaseTelemetryMeasurements{
+		Measurements: base.Measurements,
+	}
+
+	switch tele := item.(type) {
+	case *RequestTelemetry:
+		return &appinsights.RequestTelemetry{
+			Name:                      tele.Name,
+			Url:                       tele.Url,
+			Id:                        tele.Id,
+			Duration:                  tele.Duration,
+			ResponseCode:              tele.ResponseCode,
+			Success:                   tele.Success,
+			BaseTelemetry:             baseTele,
+			BaseTelemetryMeasurements: measurements,
+		}
+	case *PageViewTelemetry:
+		return &appinsights.PageViewTelemetry{
+			Name:                      tele.Name,
+			Url:                       tele.Url,
+			Duration:                  tele.Duration,
+			BaseTelemetry:             baseTele,
+			BaseTelemetryMeasurements: measurements,
+		}
+	case *DependencyTelemetry:
+		return &appinsights.RemoteDependencyTelemetry{
+			Id:                        tele.Id,
+			Name:                      tele.Name,
+			Type:                      tele.Type,
+			Target:                    tele.Target,
+			ResultCode:                tele.ResultCode,
+			Data:                      tele.Data,
+			Success:                   tele.Success,
+			Duration:                  tele.Duration,
+			BaseTelemetry:             baseTele,
+			BaseTelemetryMeasurements: measurements,
+		}
+	case *TraceTelemetry:
+		return &appinsights.TraceTelemetry{
+			Message:       tele.Message,
+			SeverityLevel: contracts.SeverityLevel(tele.SeverityLevel),
+			BaseTelemetry: baseTele,
+		}
+	case *ExceptionTelemetry:
+		exception := &appinsights.ExceptionTelemetry{
+			Error:                     tele.Error,
+			Frames:                    tele.Frames,
+			SeverityLevel:             contracts.SeverityLevel(tele.SeverityLevel),
+			BaseTelemetry:             baseTele,
+			BaseTelemetryMeasurements: measurements,
+		}
+		if tele.Message == "" &&
+			tele.ProblemId == "" &&
+			len(tele.InnerExceptions) == 0 {
+			return exception
+		}
+		return &chainedExceptionTelemetry{
+			ExceptionTelemetry: exception,
+			message:            tele.Message,
+			problemId:          tele.ProblemId,
+			inner:              tele.InnerExceptions,
+		}
+	case *MetricTelemetry:
+		if tele.Count == 0 {
+			return &appinsights.MetricTelemetry{
+				Name:          tele.Name,
+				Value:         tele.Value,
+				BaseTelemetry: baseTele,
+			}
+		}
+		return &appinsights.AggregateMetricTelemetry{
+			Name:          tele.Name,
+			Value:         tele.Value,
+			Min:           tele.Min,
+			Max:           tele.Max,
+			Count:         tele.Count,
+			StdDev:        tele.StdDev,
+			BaseTelemetry: baseTele,
+		}
+	case *EventTelemetry:
+		return &appinsights.EventTelemetry{
+			Name:                      tele.Name,
+			BaseTelemetry:             baseTele,
+			BaseTelemetryMeasurements: measurements,
+		}
+	default:
+		return nil
+	}
+}
+
+// Wraps the telemetry in an envelope the same way the TelemetryClient does,
+// applying the common properties and tags of the client context
+func envelop(
+	context *appinsights.TelemetryContext,
+	item appinsights.Telemetry,
+) *contracts.Envelope {
+	if props := item.GetProperties(); props != nil {
+		for key, val := range context.CommonProperties {
+			if _, ok := props[key]; !ok {
+				props[key] = val
+			}
+		}
+	}
+
+	tdata := item.TelemetryData()
+	data := contracts.NewData()
+	data.BaseType = tdata.BaseType()
+	data.BaseData = tdata
+
+	ikey := context.InstrumentationKey()
+	envelope := contracts.NewEnvelope()
+	envelope.Name = tdata.EnvelopeName(strings.ReplaceAll(ikey, "-", ""))
+	envelope.Data = data
+	envelope.IKey = ikey
+
+	timestamp := item.Time()
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	envelope.Time = timestamp.UTC().Format("2006-01-02T15:04:05.999999Z")
+
+	envelope.Tags = item.ContextTags()
+	if envelope.Tags == nil {
+		envelope.Tags = make(map[string]string, len(context.Tags))
+	}
+	for key, val := range context.Tags {
+		if _, ok := envelope.Tags[key]; !ok {
+			envelope.Tags[key] = val
+		}
+	}
+	if _, ok := envelope.Tags[contracts.OperationId]; !ok {
+		envelope.Tags[contracts.OperationId] = NewTraceId()
+	}
+
+	tdata.Sanitize()
+	contracts.SanitizeTags(envelope.Tags)
+	return envelope
+}
+
+// Exception telemetry with the message of the error overridden, the inner
+// exceptions appended to the exception details and the problem id set
+type chainedExceptionTelemetry struct {
+	*appinsights.ExceptionTelemetry
+	message   string
+	problemId string
+	inner     []*ExceptionDetails
+}
+
+func (tele *chainedExceptionTelemetry) TelemetryData() appinsights.TelemetryData {
+	data := tele.ExceptionTelemetry.TelemetryData().(*contracts.ExceptionData)
+	if tele.message != "" {
+		for _, details := range data.Exceptions {
+			details.Message = tele.message
+		}
+	}
+	data.ProblemId = tele.problemId
+	for _, inner := range tele.inner {
+		details := contracts.NewExceptionDetails()
+		details.Id = inner.Id
+		details.OuterId = inner.OuterId
+		details.TypeName = inner.TypeName
+		details.Message = inner.Message
+		details.HasFullStack = len(inner.Frames) > 0
+		details.ParsedStack = inner.Frames
+		data.Exceptions = append(data.Exceptions, details)
+	}
+	return data
+}
+
+func toContextTags(base *TelemetryBase) contracts.ContextTags {
+	tags := make(contracts.ContextTags, len(base.Tags)+4)
+	for key, val := range base.Tags {
+		tags[key] = val
+	}
+	if base.OperationId != "" {
+		tags.Operation().SetId(base.OperationId)
+	}
+	if base.ParentId != "" {
+		tags.Operation().SetParentId(base.ParentId)
+	}
+	if base.OperationName != "" {
+		tags.Operation().SetName(base.OperationName)
+	}
+	if base.CloudRole != "" {
+		tags.Cloud().SetRole(base.CloudRole)
+	}
+	return tags
+}
+
+// - Multi
+
+// ITelemetryExporter that fans out every telemetry item to several exporters
+type MultiExporter struct {
+	exporters []ITelemetryExporter
+}
+
+var _ ITelemetryExporter = (*MultiExporter)(nil)
+
+// Constructs a MultiExporter exporting to all of the provided exporters
+func NewMultiExporter(exporters ...ITelemetryExporter) *MultiExporter {
+	return &MultiExporter{
+		exporters: exporters,
+	}
+}
+
+func (exp *MultiExporter) Export(item ITelemetry) {
+	for _, e := range exp.exporters {
+		e.Export(item)
+	}
+}
+
+func (exp *MultiExporter) Flush() {
+	for _, e := range exp.exporters {
+		e.Flush()
+	}
+}
+
+// Closes all of the exporters concurrently
+func (exp *MultiExporter) Close() {
+	wg := sync.WaitGroup{}
+	for _, e := range exp.exporters {
+		wg.Add(1)
+		go func(e ITelemetryExporter) {
+			defer wg.Done()
+			e.Close()
+		}(e)
+	}
+	wg.Wait()
+}
+
+// - Writer
+
+// ITelemetryExporter that writes every telemetry item as a JSON line, along
+// with its telemetry type (ex: Request, Dependency), to the writer (ex:
+// os.Stdout for console output while developing)
+type WriterExporter struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+var _ ITelemetryExporter = (*WriterExporter)(nil)
+
+// Constructs a WriterExporter writing to w
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+type writerRecord struct {
+	Type      string      `json:"type"`
+	Telemetry interface{} `json:"telemetry"`
+}
+
+func (exp *WriterExporter) Export(item ITelemetry) {
+	record := writerRecord{
+		Type:      telemetryType(item),
+		Telemetry: item,
+	}
+	if ex, ok := item.(*ExceptionTelemetry); ok {
+		// errors usually have no exported fields to be marshaled
+		cpy := *ex
+		cpy.Error = exceptionMessage(ex.Error)
+		if ex.Message != "" {
+			cpy.Error = ex.Message
+		}
+		record.Telemetry = &cpy
+	}
+	exp.mtx.Lock()
+	defer exp.mtx.Unlock()
+	_ = exp.enc.Encode(record)
+}
+
+func (exp *WriterExporter) Flush() {}
+
+func (exp *WriterExporter) Close() {}
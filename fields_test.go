@@ -0,0 +1,115 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+// Calls every Trace and Track API of the core with the fields
+func traceAll(core *ait.AppInsightsCore, fields map[string]string) {
+	ctx := context.Background()
+	tid, rid := ait.NewTraceId(), ait.NewSpanId()
+	now := time.Now()
+	err := errors.New("failed")
+
+	core.TraceRequest(ctx, "GET", "/orders", "", 200, 10, "10.0.0.1", "agent", now, now, fields)
+	core.TraceRequestWithIds(tid, "", rid, "GET", "/orders", "", 200, 10, "10.0.0.1", "agent", now, now, fields)
+	core.TracePageView(ctx, "/home", 200, 10, "10.0.0.1", "agent", now, now, fields)
+	core.TracePageViewWithIds(tid, rid, "/home", 200, 10, "10.0.0.1", "agent", now, now, fields)
+	core.TraceEvent(ctx, "processed", "key", 200, now, now, fields)
+	core.TraceEventWithIds(tid, rid, ait.NewSpanId(), "processed", "key", 200, now, now, fields)
+	core.TraceDependency(ctx, ait.NewSpanId(), "SQL", "db", "SELECT", true, now, now, fields)
+	core.TraceDependencyWithIds(tid, rid, ait.NewSpanId(), "SQL", "db", "SELECT", true, now, now, fields)
+	core.TraceLog(ctx, "processed", ait.Information, fields)
+	core.TraceLogWithIds(tid, rid, "processed", 1, now, fields)
+	core.TraceException(ctx, err, 0, fields)
+	core.TraceExceptionWithIds(tid, rid, err, 0, fields)
+	core.TrackCustomEvent(ctx, "processed", fields, map[string]float64{"count": 1})
+	core.TrackCustomEventWithIds(tid, rid, "processed", fields, nil)
+	core.TrackMetric(ctx, "queueLength", 1, fields)
+	core.TraceRequestInfo(ctx, ait.RequestInfo{Method: "GET", Path: "/info", StatusCode: 200, Fields: fields})
+	core.TracePageViewInfo(ctx, ait.PageViewInfo{Path: "/info", Fields: fields})
+	core.TraceDependencyInfo(ctx, ait.DependencyInfo{Type: "SQL", Name: "SELECT", Fields: fields})
+	core.TraceLogInfo(ctx, ait.LogInfo{Message: "processed", Fields: fields})
+	core.TraceExceptionInfo(ctx, ait.ExceptionInfo{Error: err, Fields: fields})
+}
+
+func TestFieldsSharedConcurrently(t *testing.T) {
+	core, rec := appinsightstest.NewCore("fields-test")
+	redactor, err := ait.NewRedactor(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	core.AddInitializer(ait.NewPropertiesInitializer(map[string]string{
+		"region": "westus",
+		"tenant": "default",
+	}))
+	core.AddProcessor(redactor)
+
+	fields := map[string]string{
+		"password":  "hunter2",
+		"tenant":    "contoso",
+		"ip":        "192.168.0.1",
+		"bodySize":  "-1",
+		"userAgent": "caller",
+	}
+	expected := make(map[string]string, len(fields))
+	for key, val := range fields {
+		expected[key] = val
+	}
+
+	const goroutines = 8
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				traceAll(core, fields)
+			} else {
+				traceAll(core, nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("caller fields modified: %v", fields)
+	}
+	rec.RequireCount(t, goroutines*20)
+	for _, item := range rec.Items() {
+		props := item.Base().Properties
+		if props["region"] != "westus" {
+			t.Fatalf("initializer property missing: %v", props)
+		}
+		if props["password"] != "" && props["password"] == fields["password"] {
+			t.Fatalf("password not redacted: %v", props)
+		}
+	}
+	// TraceRequest and TracePageView (and their WithIds variants) set the
+	// built in properties
+	builtIn := 0
+	for _, item := range rec.Items() {
+		name := appinsightstest.ItemName(item)
+		if name != "GET /orders" && name != "GET /home" {
+			continue
+		}
+		builtIn++
+		props := item.Base().Properties
+		if props["bodySize"] != "10" ||
+			props["ip"] != "10.0.0.1" ||
+			props["userAgent"] != "agent" {
+			t.Fatalf("built in properties overwritten: %v", props)
+		}
+	}
+	if builtIn != goroutines*4 {
+		t.Errorf("expected %d items with built in properties, got %d", goroutines*4, builtIn)
+	}
+}
@@ -1,19 +1,29 @@
 module github.com/BetaLixT/appInsightsTrace
 
-go 1.18
+go 1.21
+
+require (
+	code.cloudfoundry.org/clock v1.0.0
+	github.com/pkg/errors v0.9.1
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+)
 
 require (
-	code.cloudfoundry.org/clock v1.0.0 // indirect
 	github.com/gofrs/uuid v4.2.0+incompatible // indirect
-	github.com/pkg/errors v0.9.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/stretchr/testify v1.7.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
+	golang.org/x/net v0.14.0 // indirect
+	golang.org/x/sys v0.11.0 // indirect
+	golang.org/x/text v0.12.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230822172742-b8732ec3820d // indirect
 )
 
 require (
 	github.com/microsoft/ApplicationInsights-Go v0.4.4
 	go.uber.org/zap v1.21.0
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
@@ -0,0 +1,289 @@
+package appinsightstrace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Dependency type used for outgoing gRPC calls
+const GrpcDependencyType = "GRPC"
+
+// Returns a gRPC server interceptor for unary calls that continues (or starts)
+// the trace from the traceparent and tracestate metadata of incoming calls,
+// injects it into the context (readable with TraceInfoFromContext and the
+// W3CTraceExtractor) and transmits a Request telemetry once the call has
+// completed
+func (ins *AppInsightsCore) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		ctx, trace := incomingGrpcTraceInfo(ctx)
+		resp, err := handler(ctx, req)
+		ins.traceGrpcRequest(ctx, trace, info.FullMethod, err, start)
+		return resp, err
+	}
+}
+
+// Returns a gRPC server interceptor for streaming calls, same as
+// UnaryServerInterceptor the Request telemetry is transmitted once the stream
+// handler has returned
+func (ins *AppInsightsCore) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		ctx, trace := incomingGrpcTraceInfo(ss.Context())
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+		ins.traceGrpcRequest(ctx, trace, info.FullMethod, err, start)
+		return err
+	}
+}
+
+// Returns a gRPC client interceptor for unary calls that propagates the trace
+// information of the context through the traceparent and tracestate metadata
+// and transmits a Dependency telemetry of type GRPC for every call
+func (ins *AppInsightsCore) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		start := time.Now()
+		requestId, tc := ins.outgoingTraceContext(ctx)
+		ctx = outgoingGrpcContext(ctx, tc)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		ins.traceGrpcDependency(requestId, tc, cc.Target(), method, err, start)
+		return err
+	}
+}
+
+// Returns a gRPC client interceptor for streaming calls, same as
+// UnaryClientInterceptor the Dependency telemetry is transmitted once the
+// stream has completed (RecvMsg returns io.EOF or an error), callers should
+// always receive until the end of the stream
+func (ins *AppInsightsCore) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		start := time.Now()
+		requestId, tc := ins.outgoingTraceContext(ctx)
+		ctx = outgoingGrpcContext(ctx, tc)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			ins.traceGrpcDependency(requestId, tc, cc.Target(), method, err, start)
+			return cs, err
+		}
+		return &tracedClientStream{
+			ClientStream:  cs,
+			serverStreams: desc.ServerStreams,
+			finish: func(err error) {
+				ins.traceGrpcDependency(
+					requestId,
+					tc,
+					cc.Target(),
+					method,
+					err,
+					start,
+				)
+			},
+		}, nil
+	}
+}
+
+func (ins *AppInsightsCore) traceGrpcRequest(
+	ctx context.Context,
+	trace TraceInfo,
+	method string,
+	err error,
+	start time.Time,
+) {
+	code := status.Code(err)
+	userAgent := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("user-agent"); len(vals) > 0 {
+			userAgent = vals[0]
+		}
+	}
+	ip := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	fields := map[string]string{
+		"grpcStatus": code.String(),
+		"ip":         ip,
+		"userAgent":  userAgent,
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	ins.traceRequest(
+		trace.TraceId,
+		trace.ParentId,
+		trace.SpanId,
+		method,
+		method,
+		strconv.Itoa(int(code)),
+		isGrpcServerSuccess(code),
+		start,
+		time.Now(),
+		fields,
+	)
+}
+
+func (ins *AppInsightsCore) traceGrpcDependency(
+	requestId string,
+	tc TraceContext,
+	target string,
+	method string,
+	err error,
+	start time.Time,
+) {
+	code := status.Code(err)
+	fields := map[string]string{
+		"grpcStatus": code.String(),
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	ins.traceDependency(
+		tc.TraceId,
+		requestId,
+		tc.ParentId,
+		GrpcDependencyType,
+		target,
+		strings.TrimPrefix(method, "/"),
+		strconv.Itoa(int(code)),
+		method,
+		code == codes.OK,
+		start,
+		time.Now(),
+		fields,
+	)
+}
+
+// Whether the status code of a served call should be considered successful,
+// codes caused by the caller (ex: NotFound, InvalidArgument) are not
+// considered failures of the server
+func isGrpcServerSuccess(code codes.Code) bool {
+	switch code {
+	case codes.Unknown,
+		codes.DeadlineExceeded,
+		codes.Unimplemented,
+		codes.Internal,
+		codes.Unavailable,
+		codes.DataLoss:
+		return false
+	default:
+		return true
+	}
+}
+
+func incomingGrpcTraceInfo(
+	ctx context.Context,
+) (context.Context, TraceInfo) {
+	traceparent, tracestate := "", ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(TraceParentHeader); len(vals) > 0 {
+			traceparent = vals[0]
+		}
+		tracestate = strings.Join(md.Get(TraceStateHeader), ",")
+	}
+	info := IncomingTraceInfo(traceparent, tracestate)
+	return ContextWithTraceInfo(ctx, info), info
+}
+
+func outgoingGrpcContext(
+	ctx context.Context,
+	tc TraceContext,
+) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	md.Set(TraceParentHeader, tc.TraceParent())
+	if len(tc.State) > 0 {
+		md.Set(TraceStateHeader, tc.State.String())
+	} else {
+		md.Delete(TraceStateHeader)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	finish        func(err error)
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.once.Do(func() { s.finish(nil) })
+	case err != nil:
+		s.once.Do(func() { s.finish(err) })
+	case !s.serverStreams:
+		// the server responds with a single message for client streams
+		s.once.Do(func() { s.finish(nil) })
+	}
+	return err
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.once.Do(func() { s.finish(err) })
+	}
+	return err
+}
+
+func (s *tracedClientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.once.Do(func() { s.finish(err) })
+	}
+	return md, err
+}
@@ -0,0 +1,252 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	echoMethod = "/test.Echo/Echo"
+	listMethod = "/test.Echo/List"
+)
+
+// Responds with the status named by the request value (ok, notfound or
+// internal)
+func echoStatus(value string) error {
+	switch value {
+	case "notfound":
+		return status.Error(codes.NotFound, "not found")
+	case "internal":
+		return status.Error(codes.Internal, "internal")
+	default:
+		return nil
+	}
+}
+
+func echoHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	req := &wrapperspb.StringValue{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		value := req.(*wrapperspb.StringValue).Value
+		return wrapperspb.String(value), echoStatus(value)
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: echoMethod,
+	}, handler)
+}
+
+func listHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &wrapperspb.StringValue{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.SendMsg(wrapperspb.String(strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	return echoStatus(req.Value)
+}
+
+var listStreamDesc = grpc.StreamDesc{
+	StreamName:    "List",
+	Handler:       listHandler,
+	ServerStreams: true,
+}
+
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Echo", Handler: echoHandler},
+	},
+	Streams:  []grpc.StreamDesc{listStreamDesc},
+	Metadata: "echo.proto",
+}
+
+// Starts an in-process server and a client connected to it, both traced by
+// the core
+func newGrpcPair(
+	t *testing.T,
+	core *ait.AppInsightsCore,
+) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(core.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(core.StreamServerInterceptor()),
+	)
+	srv.RegisterService(&echoServiceDesc, struct{}{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(core.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(core.StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// Checks the client dependency and the server request of a single call are
+// linked together and carry the expected result
+func requireGrpcCall(
+	t *testing.T,
+	rec *appinsightstest.Recorder,
+	caller ait.TraceInfo,
+	method string,
+	code codes.Code,
+	serverSuccess bool,
+) {
+	t.Helper()
+	deps := rec.Dependencies()
+	reqs := rec.Requests()
+	if len(deps) != 1 || len(reqs) != 1 {
+		t.Fatalf("expected 1 dependency and 1 request, got %d and %d", len(deps), len(reqs))
+	}
+	dep, req := deps[0], reqs[0]
+
+	if dep.OperationId != caller.TraceId || req.OperationId != caller.TraceId {
+		t.Errorf(
+			"operation ids %q (dependency) and %q (request), expected %q",
+			dep.OperationId,
+			req.OperationId,
+			caller.TraceId,
+		)
+	}
+	if dep.ParentId != caller.SpanId {
+		t.Errorf("dependency parent id %q, expected %q", dep.ParentId, caller.SpanId)
+	}
+	if dep.Id == "" || req.ParentId != dep.Id {
+		t.Errorf("request parent id %q, expected dependency id %q", req.ParentId, dep.Id)
+	}
+	if req.Id == "" || req.Id == dep.Id {
+		t.Errorf("unexpected request id %q", req.Id)
+	}
+
+	wantCode := strconv.Itoa(int(code))
+	if dep.Type != ait.GrpcDependencyType || dep.Data != method {
+		t.Errorf("dependency type %q and data %q", dep.Type, dep.Data)
+	}
+	if dep.ResultCode != wantCode || dep.Success != (code == codes.OK) {
+		t.Errorf("dependency result %q success %v, expected %q", dep.ResultCode, dep.Success, wantCode)
+	}
+	if req.Name != method || req.ResponseCode != wantCode || req.Success != serverSuccess {
+		t.Errorf(
+			"request %q result %q success %v, expected %q %q %v",
+			req.Name,
+			req.ResponseCode,
+			req.Success,
+			method,
+			wantCode,
+			serverSuccess,
+		)
+	}
+}
+
+var grpcCases = []struct {
+	value         string
+	code          codes.Code
+	serverSuccess bool
+}{
+	{"ok", codes.OK, true},
+	{"notfound", codes.NotFound, true},
+	{"internal", codes.Internal, false},
+}
+
+func TestGrpcUnaryInterceptors(t *testing.T) {
+	for _, tc := range grpcCases {
+		t.Run(tc.value, func(t *testing.T) {
+			core, rec := appinsightstest.NewCore("grpc-test")
+			conn := newGrpcPair(t, core)
+
+			caller := ait.IncomingTraceInfo("", "")
+			ctx := ait.ContextWithTraceInfo(context.Background(), caller)
+			resp := &wrapperspb.StringValue{}
+			err := conn.Invoke(ctx, echoMethod, wrapperspb.String(tc.value), resp)
+			if status.Code(err) != tc.code {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			requireGrpcCall(t, rec, caller, echoMethod, tc.code, tc.serverSuccess)
+		})
+	}
+}
+
+func TestGrpcStreamInterceptors(t *testing.T) {
+	for _, tc := range grpcCases {
+		t.Run(tc.value, func(t *testing.T) {
+			core, rec := appinsightstest.NewCore("grpc-test")
+			conn := newGrpcPair(t, core)
+
+			caller := ait.IncomingTraceInfo("", "")
+			ctx := ait.ContextWithTraceInfo(context.Background(), caller)
+			stream, err := conn.NewStream(ctx, &listStreamDesc, listMethod)
+			if err != nil {
+				t.Fatalf("failed to open stream: %v", err)
+			}
+			if err := stream.SendMsg(wrapperspb.String(tc.value)); err != nil {
+				t.Fatalf("failed to send: %v", err)
+			}
+			if err := stream.CloseSend(); err != nil {
+				t.Fatalf("failed to close send: %v", err)
+			}
+
+			received := 0
+			for {
+				msg := &wrapperspb.StringValue{}
+				err = stream.RecvMsg(msg)
+				if err != nil {
+					break
+				}
+				received++
+				if len(rec.Dependencies()) != 0 {
+					t.Fatal("dependency transmitted before the end of the stream")
+				}
+			}
+			if received != 3 {
+				t.Errorf("received %d messages, expected 3", received)
+			}
+			if tc.code == codes.OK && !errors.Is(err, io.EOF) {
+				t.Fatalf("expected io.EOF, got %v", err)
+			}
+			if status.Code(err) != tc.code && tc.code != codes.OK {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			requireGrpcCall(t, rec, caller, listMethod, tc.code, tc.serverSuccess)
+		})
+	}
+}
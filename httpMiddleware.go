@@ -0,0 +1,330 @@
+package appinsightstrace
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options for the http middleware
+//
+// RouteTemplates: route templates (ex: /api/v1/users/{id}) used to name the
+//
+//	request telemetry so requests to the same route are grouped together,
+//	segments wrapped in braces match any single path segment and a trailing
+//	"*" segment matches the rest of the path, the first matching template is
+//	used and the raw path is used if none match
+//
+// RouteNameFunc: optional function resolving the route name of a request,
+//
+//	takes precedence over RouteTemplates if it returns a non empty string
+//
+// TrustForwardedFor: whether to use the X-Forwarded-For header to resolve the
+//
+//	ip of the client, should only be enabled behind a trusted proxy
+//
+// Recovery: recovers panics of the handler if set, the panic is traced as an
+//
+//	exception and the request is traced as failed with a 500 status code, a
+//	500 response is written if the panic is swallowed and the handler has not
+//	written a response yet
+type HttpMiddlewareOptions struct {
+	RouteTemplates    []string
+	RouteNameFunc     func(r *http.Request) string
+	TrustForwardedFor bool
+	Recovery          *RecoveryOptions
+}
+
+// Wraps the handler with a middleware that continues the trace from the
+// traceparent and tracestate headers of incoming requests (or starts a new
+// one), injects the trace information into the request context (readable
+// with TraceInfoFromContext and the W3CTraceExtractor) and transmits a
+// Request telemetry once the handler has completed
+func (ins *AppInsightsCore) HttpMiddleware(
+	next http.Handler,
+) http.Handler {
+	return ins.HttpMiddlewareWithOptions(next, &HttpMiddlewareOptions{})
+}
+
+// Same as HttpMiddleware but with options, check documentation of
+// HttpMiddlewareOptions for more information
+func (ins *AppInsightsCore) HttpMiddlewareWithOptions(
+	next http.Handler,
+	optn *HttpMiddlewareOptions,
+) http.Handler {
+	if optn == nil {
+		optn = &HttpMiddlewareOptions{}
+	}
+	templates := make([][]string, 0, len(optn.RouteTemplates))
+	for _, tmpl := range optn.RouteTemplates {
+		templates = append(templates, splitPath(tmpl))
+	}
+	return &httpMiddleware{
+		ins:       ins,
+		next:      next,
+		optn:      optn,
+		templates: templates,
+	}
+}
+
+type httpMiddleware struct {
+	ins       *AppInsightsCore
+	next      http.Handler
+	optn      *HttpMiddlewareOptions
+	templates [][]string
+}
+
+func (m *httpMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	info := IncomingTraceInfo(
+		r.Header.Get(TraceParentHeader),
+		r.Header.Get(TraceStateHeader),
+	)
+	ctx := ContextWithTraceInfo(r.Context(), info)
+
+	rw, rec := wrapResponseWriter(w)
+	if m.optn.Recovery != nil {
+		defer m.recoverPanic(ctx, r, info, rec, start)
+	}
+	m.next.ServeHTTP(rw, r.WithContext(ctx))
+	m.traceRequest(r, info, rec, rec.statusCode(), start)
+}
+
+// Recovers a panic of the handler, must be deferred directly
+func (m *httpMiddleware) recoverPanic(
+	ctx context.Context,
+	r *http.Request,
+	info TraceInfo,
+	rec *responseRecorder,
+	start time.Time,
+) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	// aborting the handler is not an error and is always propagated for the
+	// server to abort the response
+	if recovered == http.ErrAbortHandler {
+		m.traceRequest(r, info, rec, http.StatusInternalServerError, start)
+		panic(recovered)
+	}
+	m.ins.tracePanic(ctx, recovered)
+	m.traceRequest(r, info, rec, http.StatusInternalServerError, start)
+	if !m.optn.Recovery.RePanic && rec.status == 0 {
+		rec.WriteHeader(http.StatusInternalServerError)
+	}
+	m.ins.endPanic(recovered, m.optn.Recovery)
+}
+
+func (m *httpMiddleware) traceRequest(
+	r *http.Request,
+	info TraceInfo,
+	rec *responseRecorder,
+	statusCode int,
+	start time.Time,
+) {
+	url := r.URL.Path
+	if r.URL.RawQuery != "" {
+		url = url + "?" + r.URL.RawQuery
+	}
+	props := map[string]string{
+		"bodySize":  strconv.Itoa(rec.bytesWritten),
+		"ip":        m.clientIp(r),
+		"userAgent": r.UserAgent(),
+	}
+	m.ins.traceRequest(
+		info.TraceId,
+		info.ParentId,
+		info.SpanId,
+		r.Method+" "+m.routeName(r),
+		url,
+		strconv.Itoa(statusCode),
+		statusCode > 99 && statusCode < 300,
+		start,
+		time.Now(),
+		props,
+	)
+}
+
+func (m *httpMiddleware) routeName(r *http.Request) string {
+	if m.optn.RouteNameFunc != nil {
+		if name := m.optn.RouteNameFunc(r); name != "" {
+			return name
+		}
+	}
+	if len(m.templates) > 0 {
+		segments := splitPath(r.URL.Path)
+		for i, tmpl := range m.templates {
+			if matchRouteTemplate(tmpl, segments) {
+				return m.optn.RouteTemplates[i]
+			}
+		}
+	}
+	return r.URL.Path
+}
+
+func (m *httpMiddleware) clientIp(r *http.Request) string {
+	if m.optn.TrustForwardedFor {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			if idx := strings.IndexByte(fwd, ','); idx >= 0 {
+				fwd = fwd[:idx]
+			}
+			return strings.TrimSpace(fwd)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func matchRouteTemplate(tmpl []string, segments []string) bool {
+	for i, seg := range tmpl {
+		if seg == "*" && i == len(tmpl)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			continue
+		}
+		if seg != segments[i] {
+			return false
+		}
+	}
+	return len(tmpl) == len(segments)
+}
+
+// - Response writer
+
+// Records the status code and number of bytes written by the handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status       int
+	bytesWritten int
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	// informational responses may be written multiple times before the
+	// final status
+	if rec.status == 0 && statusCode >= 200 {
+		rec.status = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytesWritten += n
+	return n, err
+}
+
+// Allows http.ResponseController to reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+type flusher struct{ rec *responseRecorder }
+
+func (f flusher) Flush() {
+	if f.rec.status == 0 {
+		f.rec.status = http.StatusOK
+	}
+	f.rec.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct{ rec *responseRecorder }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h.rec.status == 0 {
+		h.rec.status = http.StatusSwitchingProtocols
+	}
+	return h.rec.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type pusher struct{ rec *responseRecorder }
+
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.rec.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// Wraps the response writer with a recorder, the returned writer implements
+// http.Flusher, http.Hijacker and http.Pusher only if the original writer
+// does so type assertions by handlers keep working as expected
+func wrapResponseWriter(
+	w http.ResponseWriter,
+) (http.ResponseWriter, *responseRecorder) {
+	rec := &responseRecorder{ResponseWriter: w}
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isPusher := w.(http.Pusher)
+
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+			pusher
+		}{rec, flusher{rec}, hijacker{rec}, pusher{rec}}, rec
+	case isFlusher && isHijacker:
+		return struct {
+			*responseRecorder
+			flusher
+			hijacker
+		}{rec, flusher{rec}, hijacker{rec}}, rec
+	case isFlusher && isPusher:
+		return struct {
+			*responseRecorder
+			flusher
+			pusher
+		}{rec, flusher{rec}, pusher{rec}}, rec
+	case isHijacker && isPusher:
+		return struct {
+			*responseRecorder
+			hijacker
+			pusher
+		}{rec, hijacker{rec}, pusher{rec}}, rec
+	case isFlusher:
+		return struct {
+			*responseRecorder
+			flusher
+		}{rec, flusher{rec}}, rec
+	case isHijacker:
+		return struct {
+			*responseRecorder
+			hijacker
+		}{rec, hijacker{rec}}, rec
+	case isPusher:
+		return struct {
+			*responseRecorder
+			pusher
+		}{rec, pusher{rec}}, rec
+	default:
+		return rec, rec
+	}
+}
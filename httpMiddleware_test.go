@@ -0,0 +1,275 @@
+package appinsightstrace_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+func TestHttpMiddlewareRouteNames(t *testing.T) {
+	optn := &ait.HttpMiddlewareOptions{
+		RouteTemplates: []string{
+			"/api/v1/users/{id}",
+			"/api/v1/users/{id}/orders/{orderId}",
+			"/files/*",
+			"/health",
+		},
+		RouteNameFunc: func(r *http.Request) string {
+			if r.URL.Path == "/custom" {
+				return "/named"
+			}
+			return ""
+		},
+	}
+	cases := map[string]string{
+		"/api/v1/users/42":           "GET /api/v1/users/{id}",
+		"/api/v1/users/42/":          "GET /api/v1/users/{id}",
+		"/api/v1/users/42/orders/7":  "GET /api/v1/users/{id}/orders/{orderId}",
+		"/api/v1/users/42/invoices":  "GET /api/v1/users/42/invoices",
+		"/api/v1/users":              "GET /api/v1/users",
+		"/files/reports/2024/q1.pdf": "GET /files/*",
+		"/health":                    "GET /health",
+		"/custom":                    "GET /named",
+		"/":                          "GET /",
+	}
+	for path, expected := range cases {
+		core, rec := appinsightstest.NewCore("middleware-test")
+		handler := core.HttpMiddlewareWithOptions(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			optn,
+		)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path+"?q=1", nil))
+		req := rec.RequireRequest(t, expected)
+		if req.Url != path+"?q=1" {
+			t.Errorf("url %q, expected %q", req.Url, path+"?q=1")
+		}
+	}
+}
+
+func TestHttpMiddlewareStatusCapture(t *testing.T) {
+	cases := map[string]struct {
+		handler  http.HandlerFunc
+		code     string
+		success  bool
+		bodySize string
+	}{
+		"no write": {
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			code:    "200", success: true, bodySize: "0",
+		},
+		"body only": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "hello")
+			},
+			code: "200", success: true, bodySize: "5",
+		},
+		"not found": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			code: "404", success: false, bodySize: "19",
+		},
+		"informational then created": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusCreated)
+			},
+			code: "201", success: true, bodySize: "0",
+		},
+		"server error": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.WriteHeader(http.StatusOK)
+			},
+			code: "500", success: false, bodySize: "0",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			core, rec := appinsightstest.NewCore("middleware-test")
+			core.HttpMiddleware(c.handler).ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest("GET", "/status", nil),
+			)
+			req := rec.RequireRequest(t, "GET /status")
+			if req.ResponseCode != c.code || req.Success != c.success {
+				t.Errorf("response %s success %v, expected %s %v", req.ResponseCode, req.Success, c.code, c.success)
+			}
+			if req.Properties["bodySize"] != c.bodySize {
+				t.Errorf("body size %s, expected %s", req.Properties["bodySize"], c.bodySize)
+			}
+		})
+	}
+}
+
+// Optional interfaces implemented by the fake response writers, recording
+// whether they were reached
+type fakeFlusher struct{ calls *[]string }
+
+func (f fakeFlusher) Flush() { *f.calls = append(*f.calls, "flush") }
+
+type fakeHijacker struct{ calls *[]string }
+
+func (h fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	*h.calls = append(*h.calls, "hijack")
+	return nil, nil, nil
+}
+
+type fakePusher struct{ calls *[]string }
+
+func (p fakePusher) Push(target string, opts *http.PushOptions) error {
+	*p.calls = append(*p.calls, "push")
+	return nil
+}
+
+// Response writer hiding the optional interfaces of the httptest recorder
+type plainWriter struct{ w http.ResponseWriter }
+
+func (p plainWriter) Header() http.Header         { return p.w.Header() }
+func (p plainWriter) Write(b []byte) (int, error) { return p.w.Write(b) }
+func (p plainWriter) WriteHeader(statusCode int)  { p.w.WriteHeader(statusCode) }
+
+// Builds a response writer implementing the optional interfaces selected
+func newFakeWriter(flush, hijack, push bool, calls *[]string) http.ResponseWriter {
+	w := plainWriter{httptest.NewRecorder()}
+	f, h, p := fakeFlusher{calls}, fakeHijacker{calls}, fakePusher{calls}
+	switch {
+	case flush && hijack && push:
+		return struct {
+			plainWriter
+			fakeFlusher
+			fakeHijacker
+			fakePusher
+		}{w, f, h, p}
+	case flush && hijack:
+		return struct {
+			plainWriter
+			fakeFlusher
+			fakeHijacker
+		}{w, f, h}
+	case flush && push:
+		return struct {
+			plainWriter
+			fakeFlusher
+			fakePusher
+		}{w, f, p}
+	case hijack && push:
+		return struct {
+			plainWriter
+			fakeHijacker
+			fakePusher
+		}{w, h, p}
+	case flush:
+		return struct {
+			plainWriter
+			fakeFlusher
+		}{w, f}
+	case hijack:
+		return struct {
+			plainWriter
+			fakeHijacker
+		}{w, h}
+	case push:
+		return struct {
+			plainWriter
+			fakePusher
+		}{w, p}
+	default:
+		return w
+	}
+}
+
+func TestHttpMiddlewarePreservesInterfaces(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		flush, hijack, push := i&1 != 0, i&2 != 0, i&4 != 0
+		calls := []string{}
+		core, rec := appinsightstest.NewCore("middleware-test")
+		handler := core.HttpMiddleware(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				f, isFlusher := w.(http.Flusher)
+				h, isHijacker := w.(http.Hijacker)
+				p, isPusher := w.(http.Pusher)
+				if isFlusher != flush || isHijacker != hijack || isPusher != push {
+					t.Errorf(
+						"flusher %v hijacker %v pusher %v, expected %v %v %v",
+						isFlusher, isHijacker, isPusher, flush, hijack, push,
+					)
+					return
+				}
+				if push {
+					p.Push("/style.css", nil)
+				}
+				if hijack {
+					h.Hijack()
+				} else if flush {
+					f.Flush()
+				}
+			},
+		))
+		handler.ServeHTTP(
+			newFakeWriter(flush, hijack, push, &calls),
+			httptest.NewRequest("GET", "/stream", nil),
+		)
+
+		expected := []string{}
+		code := "200"
+		if push {
+			expected = append(expected, "push")
+		}
+		if hijack {
+			expected = append(expected, "hijack")
+			code = "101"
+		} else if flush {
+			expected = append(expected, "flush")
+		}
+		if len(calls) != len(expected) {
+			t.Errorf("combination %d reached %v, expected %v", i, calls, expected)
+		}
+		if req := rec.RequireRequest(t, "GET /stream"); req.ResponseCode != code {
+			t.Errorf("combination %d response code %s, expected %s", i, req.ResponseCode, code)
+		}
+	}
+}
+
+func TestHttpMiddlewareServerInterfaces(t *testing.T) {
+	core, rec := appinsightstest.NewCore("middleware-test")
+	srv := httptest.NewServer(core.HttpMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := w.(http.Hijacker); !ok {
+				t.Errorf("http.Hijacker of the server writer dropped")
+			}
+			f, ok := w.(http.Flusher)
+			if !ok {
+				t.Errorf("http.Flusher of the server writer dropped")
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: hello\n\n")
+			f.Flush()
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("response controller flush failed: %v", err)
+			}
+		},
+	)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "data: hello\n\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+	srv.Close()
+	if req := rec.RequireRequest(t, "GET /events"); req.ResponseCode != "200" {
+		t.Errorf("response code %s, expected 200", req.ResponseCode)
+	}
+}
@@ -0,0 +1,150 @@
+package appinsightstrace
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Name of the legacy Application Insights request id header
+	RequestIdHeader = "Request-Id"
+	// Name of the legacy Application Insights request context header
+	RequestContextHeader = "Request-Context"
+)
+
+// Options for the http transport
+//
+// LegacyHeaders: whether to also inject the legacy Request-Id and
+//
+//	Request-Context headers for services instrumented with older Application
+//	Insights SDKs
+//
+// AppId: the Application Insights application id sent with the
+//
+//	Request-Context header, the header is omitted if left empty
+//
+// DependencyType: the type of the dependency telemetry, defaults to HTTP
+type HttpTransportOptions struct {
+	LegacyHeaders  bool
+	AppId          string
+	DependencyType string
+}
+
+// Wraps the round tripper (http.DefaultTransport if nil) with a transport that
+// propagates the w3c trace information of the request context through the
+// traceparent and tracestate headers and transmits a Dependency telemetry for
+// every outgoing request, the generated span id is used as the Id of the
+// telemetry so the called service's Request telemetry is linked to it
+func (ins *AppInsightsCore) HttpTransport(
+	base http.RoundTripper,
+) http.RoundTripper {
+	return ins.HttpTransportWithOptions(base, &HttpTransportOptions{})
+}
+
+// Same as HttpTransport but with options, check documentation of
+// HttpTransportOptions for more information
+func (ins *AppInsightsCore) HttpTransportWithOptions(
+	base http.RoundTripper,
+	optn *HttpTransportOptions,
+) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if optn == nil {
+		optn = &HttpTransportOptions{}
+	}
+	depType := optn.DependencyType
+	if depType == "" {
+		depType = "HTTP"
+	}
+	return &httpTransport{
+		ins:     ins,
+		base:    base,
+		optn:    optn,
+		depType: depType,
+	}
+}
+
+type httpTransport struct {
+	ins     *AppInsightsCore
+	base    http.RoundTripper
+	optn    *HttpTransportOptions
+	depType string
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	requestId, tc := t.ins.outgoingTraceContext(req.Context())
+
+	// the round tripper must not modify the provided request
+	req = req.Clone(req.Context())
+	req.Header.Set(TraceParentHeader, tc.TraceParent())
+	if len(tc.State) > 0 {
+		req.Header.Set(TraceStateHeader, tc.State.String())
+	}
+	if t.optn.LegacyHeaders {
+		req.Header.Set(RequestIdHeader, "|"+tc.TraceId+"."+tc.ParentId+".")
+		if t.optn.AppId != "" {
+			req.Header.Set(RequestContextHeader, "appId=cid-v1:"+t.optn.AppId)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	end := time.Now()
+
+	fields := map[string]string{}
+	resultCode := ""
+	success := false
+	if err != nil {
+		fields["error"] = err.Error()
+	} else {
+		resultCode = strconv.Itoa(resp.StatusCode)
+		success = resp.StatusCode < 400
+	}
+	t.ins.traceDependency(
+		tc.TraceId,
+		requestId,
+		tc.ParentId,
+		t.depType,
+		req.URL.Host,
+		req.Method+" "+req.URL.Host+req.URL.Path,
+		resultCode,
+		req.URL.Redacted(),
+		success,
+		start,
+		end,
+		fields,
+	)
+	return resp, err
+}
+
+// Builds the trace context to be propagated to an outgoing call made within
+// the current operation, the parent id of the returned context is a newly
+// generated span id that should be used as the id of the dependency
+// telemetry, a new trace is started if the context carries no valid trace
+func (ins *AppInsightsCore) outgoingTraceContext(
+	ctx context.Context,
+) (requestId string, tc TraceContext) {
+	_, tid, _, rid, flg := ins.traceExtractor.ExtractTraceInfo(ctx)
+	if !IsValidTraceId(tid) {
+		tid = NewTraceId()
+		rid = ""
+	}
+	if len(flg) != 2 || !isLowerHex(flg) {
+		flg = TraceFlagsSampled
+	}
+	tc = TraceContext{
+		Version:  TraceParentVersion,
+		TraceId:  tid,
+		ParentId: NewSpanId(),
+		Flags:    flg,
+	}
+	if info, ok := TraceInfoFromContext(ctx); ok && info.State != "" {
+		if state, err := ParseTraceState(info.State); err == nil {
+			tc.State = state
+		}
+	}
+	return rid, tc
+}
@@ -0,0 +1,117 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+func TestHttpTransportPropagation(t *testing.T) {
+	received := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Clone()
+			w.WriteHeader(http.StatusNotFound)
+		},
+	))
+	defer srv.Close()
+
+	core, rec := appinsightstest.NewCore("transport-test")
+	client := &http.Client{
+		Transport: core.HttpTransportWithOptions(nil, &ait.HttpTransportOptions{
+			LegacyHeaders: true,
+			AppId:         "1234",
+		}),
+	}
+	tid, sid := ait.NewTraceId(), ait.NewSpanId()
+	ctx := ait.ContextWithTraceInfo(context.Background(), ait.TraceInfo{
+		Version: "00",
+		TraceId: tid,
+		SpanId:  sid,
+		Flags:   "01",
+		State:   "congo=t61rcWkgMzE",
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/orders/42?token=secret", nil)
+	req.Header.Set("X-Caller", "kept")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	headers := <-received
+
+	// the caller's request is not modified
+	if len(req.Header) != 1 || req.Header.Get("X-Caller") != "kept" {
+		t.Errorf("caller request headers modified: %v", req.Header)
+	}
+
+	dep := rec.RequireDependencyUnder(t, sid)
+	tc, err := ait.ParseTraceParent(headers.Get(ait.TraceParentHeader))
+	if err != nil {
+		t.Fatalf("invalid traceparent %q: %v", headers.Get(ait.TraceParentHeader), err)
+	}
+	if tc.TraceId != tid || tc.Flags != "01" {
+		t.Errorf("traceparent %q does not continue trace %s", headers.Get(ait.TraceParentHeader), tid)
+	}
+	if tc.ParentId != dep.Id || tc.ParentId == sid {
+		t.Errorf("propagated span id %s, expected the dependency id %s", tc.ParentId, dep.Id)
+	}
+	if state := headers.Get(ait.TraceStateHeader); state != "congo=t61rcWkgMzE" {
+		t.Errorf("tracestate %q, expected congo=t61rcWkgMzE", state)
+	}
+	if id := headers.Get(ait.RequestIdHeader); id != "|"+tid+"."+dep.Id+"." {
+		t.Errorf("Request-Id %q, expected |%s.%s.", id, tid, dep.Id)
+	}
+	if rc := headers.Get(ait.RequestContextHeader); rc != "appId=cid-v1:1234" {
+		t.Errorf("Request-Context %q, expected appId=cid-v1:1234", rc)
+	}
+	if headers.Get("X-Caller") != "kept" {
+		t.Errorf("caller header not sent")
+	}
+
+	host := req.URL.Host
+	if dep.OperationId != tid ||
+		dep.Name != "GET "+host+"/orders/42" ||
+		dep.Type != "HTTP" ||
+		dep.Target != host ||
+		dep.ResultCode != "404" ||
+		dep.Success {
+		t.Errorf("unexpected dependency %+v", dep)
+	}
+}
+
+func TestHttpTransportNewTrace(t *testing.T) {
+	received := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Clone()
+		},
+	))
+	defer srv.Close()
+
+	core, rec := appinsightstest.NewCore("transport-test")
+	client := &http.Client{Transport: core.HttpTransport(nil)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	headers := <-received
+
+	dep := rec.RequireDependency(t, "GET "+resp.Request.URL.Host)
+	tc, err := ait.ParseTraceParent(headers.Get(ait.TraceParentHeader))
+	if err != nil || tc.TraceId != dep.OperationId || tc.ParentId != dep.Id {
+		t.Errorf("traceparent %q does not match dependency %+v", headers.Get(ait.TraceParentHeader), dep)
+	}
+	if headers.Get(ait.RequestIdHeader) != "" || headers.Get(ait.TraceStateHeader) != "" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+	if dep.ParentId != "" || !dep.Success || dep.ResultCode != "200" {
+		t.Errorf("unexpected dependency %+v", dep)
+	}
+}
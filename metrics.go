@@ -0,0 +1,289 @@
+package appinsightstrace
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transmits a new metric telemetry with a single measured value, for metrics
+// tracked at a high frequency it's recommended to use the MetricManager
+// instead so the values are pre-aggregated
+//
+// ctx: the current context of the execution, the ITraceExtractor.ExtractTraceInfo
+//
+//	function will be utilized to extract traceId and current requestId from
+//	the context
+//
+// name: the name of the metric
+// value: the measured value
+// fields: additional custom values (dimensions) to include in the telemetry
+func (ins *AppInsightsCore) TrackMetric(
+	ctx context.Context,
+	name string,
+	value float64,
+	fields map[string]string,
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+
+	tele := &MetricTelemetry{
+		Name:          name,
+		Value:         value,
+		TelemetryBase: ins.newTelemetryBase(time.Now(), tid, rid, fields),
+	}
+	ins.track(tele)
+}
+
+const (
+	// Default interval at which the MetricManager flushes the aggregates
+	DefaultMetricInterval = 60 * time.Second
+	// Default maximum number of dimension sets tracked per metric
+	DefaultMaxMetricSeries = 1000
+	// Default maximum number of distinct values tracked per dimension
+	DefaultMaxDimensionValues = 100
+)
+
+// Options for the metric manager
+//
+// Interval: the interval at which the aggregates are transmitted, defaults to
+//
+//	DefaultMetricInterval
+//
+// MaxSeries: the maximum number of dimension sets tracked per metric name
+//
+//	within an interval, defaults to DefaultMaxMetricSeries
+//
+// MaxDimensionValues: the maximum number of distinct values tracked per
+//
+//	dimension of a metric within an interval, defaults to
+//	DefaultMaxDimensionValues
+type MetricManagerOptions struct {
+	Interval           time.Duration
+	MaxSeries          int
+	MaxDimensionValues int
+}
+
+// Pre-aggregates metric values in memory and transmits a single aggregate
+// metric telemetry (count, sum, min, max and standard deviation) per metric
+// name and dimension set every interval, timestamped with the start of the
+// interval, constructed with AppInsightsCore.NewMetricManager
+type MetricManager struct {
+	ins                *AppInsightsCore
+	interval           time.Duration
+	maxSeries          int
+	maxDimensionValues int
+
+	mtx     sync.Mutex
+	metrics map[string]*metricAggregates
+	// start of the current interval
+	start  time.Time
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type metricAggregates struct {
+	series map[string]*metricSeries
+	// distinct values seen per dimension
+	values map[string]map[string]struct{}
+}
+
+type metricSeries struct {
+	dimensions map[string]string
+	count      int
+	sum        float64
+	min        float64
+	max        float64
+	mean       float64
+	// sum of squared differences from the mean (Welford's algorithm)
+	m2 float64
+}
+
+// Constructs a MetricManager that flushes the aggregates through the
+// AppInsightsCore every interval, Close should be called before closing the
+// AppInsightsCore so the remaining aggregates are transmitted
+func (ins *AppInsightsCore) NewMetricManager(
+	optn *MetricManagerOptions,
+) *MetricManager {
+	if optn == nil {
+		optn = &MetricManagerOptions{}
+	}
+	mgr := &MetricManager{
+		ins:                ins,
+		interval:           optn.Interval,
+		maxSeries:          optn.MaxSeries,
+		maxDimensionValues: optn.MaxDimensionValues,
+		metrics:            map[string]*metricAggregates{},
+		start:              time.Now(),
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	if mgr.interval <= 0 {
+		mgr.interval = DefaultMetricInterval
+	}
+	if mgr.maxSeries <= 0 {
+		mgr.maxSeries = DefaultMaxMetricSeries
+	}
+	if mgr.maxDimensionValues <= 0 {
+		mgr.maxDimensionValues = DefaultMaxDimensionValues
+	}
+	go mgr.run()
+	return mgr
+}
+
+// Adds the value to the aggregate of the metric for the dimensions, returns
+// false if the value was dropped because the dimension set would exceed the
+// series or dimension value caps of the metric for the current interval, or
+// because the manager is closed
+func (mgr *MetricManager) Track(
+	name string,
+	value float64,
+	dimensions map[string]string,
+) bool {
+	key := metricSeriesKey(dimensions)
+
+	mgr.mtx.Lock()
+	defer mgr.mtx.Unlock()
+	if mgr.closed {
+		return false
+	}
+
+	agg, ok := mgr.metrics[name]
+	if !ok {
+		agg = &metricAggregates{
+			series: map[string]*metricSeries{},
+			values: map[string]map[string]struct{}{},
+		}
+		mgr.metrics[name] = agg
+	}
+
+	series, ok := agg.series[key]
+	if !ok {
+		if len(agg.series) >= mgr.maxSeries {
+			return false
+		}
+		for dim, val := range dimensions {
+			if _, seen := agg.values[dim][val]; !seen &&
+				len(agg.values[dim]) >= mgr.maxDimensionValues {
+				return false
+			}
+		}
+		dims := make(map[string]string, len(dimensions))
+		for dim, val := range dimensions {
+			dims[dim] = val
+			if agg.values[dim] == nil {
+				agg.values[dim] = map[string]struct{}{}
+			}
+			agg.values[dim][val] = struct{}{}
+		}
+		series = &metricSeries{dimensions: dims}
+		agg.series[key] = series
+	}
+	series.add(value)
+	return true
+}
+
+// Transmits the aggregates of the current interval and resets them
+func (mgr *MetricManager) Flush() {
+	mgr.mtx.Lock()
+	metrics, start := mgr.metrics, mgr.start
+	mgr.metrics = map[string]*metricAggregates{}
+	mgr.start = time.Now()
+	mgr.mtx.Unlock()
+
+	for name, agg := range metrics {
+		for _, series := range agg.series {
+			mgr.trackSeries(name, series, start)
+		}
+	}
+}
+
+// Stops the periodic flush and transmits the remaining aggregates, values
+// tracked afterwards are dropped
+func (mgr *MetricManager) Close() {
+	mgr.once.Do(func() {
+		close(mgr.stop)
+		<-mgr.done
+		mgr.mtx.Lock()
+		mgr.closed = true
+		mgr.mtx.Unlock()
+		mgr.Flush()
+	})
+}
+
+func (mgr *MetricManager) run() {
+	defer close(mgr.done)
+	ticker := time.NewTicker(mgr.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mgr.Flush()
+		case <-mgr.stop:
+			return
+		}
+	}
+}
+
+func (mgr *MetricManager) trackSeries(
+	name string,
+	series *metricSeries,
+	timestamp time.Time,
+) {
+	if series.count == 0 {
+		return
+	}
+	props := make(map[string]string, len(series.dimensions))
+	for dim, val := range series.dimensions {
+		props[dim] = val
+	}
+	tele := &MetricTelemetry{
+		Name:          name,
+		Value:         series.sum,
+		Min:           series.min,
+		Max:           series.max,
+		Count:         series.count,
+		StdDev:        math.Sqrt(series.m2 / float64(series.count)),
+		TelemetryBase: mgr.ins.newTelemetryBase(timestamp, "", "", props),
+	}
+	mgr.ins.track(tele)
+}
+
+func (series *metricSeries) add(value float64) {
+	series.count++
+	series.sum += value
+	if series.count == 1 || value < series.min {
+		series.min = value
+	}
+	if series.count == 1 || value > series.max {
+		series.max = value
+	}
+	delta := value - series.mean
+	series.mean += delta / float64(series.count)
+	series.m2 += delta * (value - series.mean)
+}
+
+// Builds a key unique to the dimension set regardless of the map ordering
+func metricSeriesKey(dimensions map[string]string) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(dimensions))
+	for key := range dimensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bldr := strings.Builder{}
+	for _, key := range keys {
+		bldr.WriteString(key)
+		bldr.WriteByte(0)
+		bldr.WriteString(dimensions[key])
+		bldr.WriteByte(0)
+	}
+	return bldr.String()
+}
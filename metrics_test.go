@@ -0,0 +1,184 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+// Constructs a metric manager that only flushes when asked to
+func newTestMetricManager(
+	t *testing.T,
+	optn ait.MetricManagerOptions,
+) (*ait.MetricManager, *appinsightstest.Recorder) {
+	core, rec := appinsightstest.NewCore("metrics-test")
+	optn.Interval = time.Hour
+	mgr := core.NewMetricManager(&optn)
+	t.Cleanup(mgr.Close)
+	return mgr, rec
+}
+
+// Gets the recorded aggregate of the metric for the region
+func requireAggregate(
+	t *testing.T,
+	rec *appinsightstest.Recorder,
+	name string,
+	region string,
+) *ait.MetricTelemetry {
+	t.Helper()
+	for _, metric := range rec.Metrics() {
+		if metric.Name == name && metric.Properties["region"] == region {
+			return metric
+		}
+	}
+	t.Fatalf("no aggregate of %s for region %q in %d metrics", name, region, len(rec.Metrics()))
+	return nil
+}
+
+func TestMetricManagerAggregates(t *testing.T) {
+	mgr, rec := newTestMetricManager(t, ait.MetricManagerOptions{})
+	for _, value := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		mgr.Track("latency", value, map[string]string{"region": "eu"})
+	}
+	mgr.Track("latency", 10, map[string]string{"region": "us"})
+	mgr.Track("latency", 1, nil)
+	mgr.Flush()
+
+	rec.RequireCount(t, 3)
+	eu := requireAggregate(t, rec, "latency", "eu")
+	if eu.Count != 8 || eu.Value != 40 || eu.Min != 2 || eu.Max != 9 || eu.StdDev != 2 {
+		t.Errorf("unexpected aggregate %+v", eu)
+	}
+	us := requireAggregate(t, rec, "latency", "us")
+	if us.Count != 1 || us.Value != 10 || us.Min != 10 || us.Max != 10 || us.StdDev != 0 {
+		t.Errorf("unexpected aggregate %+v", us)
+	}
+	if none := requireAggregate(t, rec, "latency", ""); len(none.Properties) != 0 {
+		t.Errorf("unexpected dimensions %v", none.Properties)
+	}
+
+	// the aggregates are reset by the flush
+	rec.Reset()
+	mgr.Flush()
+	rec.RequireCount(t, 0)
+}
+
+func TestMetricManagerStdDevPrecision(t *testing.T) {
+	mgr, rec := newTestMetricManager(t, ait.MetricManagerOptions{})
+	// a naive sum of squares loses the variance of large values
+	for _, offset := range []float64{4, 7, 13, 16} {
+		mgr.Track("bytes", 1e9+offset, nil)
+	}
+	mgr.Flush()
+
+	metric := requireAggregate(t, rec, "bytes", "")
+	if expected := math.Sqrt(22.5); math.Abs(metric.StdDev-expected) > 1e-6 {
+		t.Errorf("stddev %v, expected %v", metric.StdDev, expected)
+	}
+}
+
+func TestMetricManagerCaps(t *testing.T) {
+	t.Run("series", func(t *testing.T) {
+		mgr, rec := newTestMetricManager(t, ait.MetricManagerOptions{MaxSeries: 2})
+		for _, region := range []string{"eu", "us"} {
+			if !mgr.Track("orders", 1, map[string]string{"region": region}) {
+				t.Fatalf("value for %s dropped", region)
+			}
+		}
+		if mgr.Track("orders", 1, map[string]string{"region": "asia"}) {
+			t.Errorf("value exceeding the series cap kept")
+		}
+		if !mgr.Track("orders", 1, map[string]string{"region": "eu"}) {
+			t.Errorf("value of an existing series dropped")
+		}
+		if !mgr.Track("payments", 1, map[string]string{"region": "asia"}) {
+			t.Errorf("series cap applied across metric names")
+		}
+		mgr.Flush()
+		rec.RequireCount(t, 3)
+
+		// the caps are reset every interval
+		if !mgr.Track("orders", 1, map[string]string{"region": "asia"}) {
+			t.Errorf("series cap not reset by the flush")
+		}
+	})
+
+	t.Run("dimension values", func(t *testing.T) {
+		mgr, _ := newTestMetricManager(t, ait.MetricManagerOptions{MaxDimensionValues: 2})
+		mgr.Track("orders", 1, map[string]string{"region": "eu", "tier": "free"})
+		mgr.Track("orders", 1, map[string]string{"region": "us", "tier": "free"})
+		if mgr.Track("orders", 1, map[string]string{"region": "asia", "tier": "free"}) {
+			t.Errorf("value exceeding the dimension value cap kept")
+		}
+		if !mgr.Track("orders", 1, map[string]string{"region": "eu", "tier": "paid"}) {
+			t.Errorf("value with known region and new tier dropped")
+		}
+	})
+}
+
+func TestMetricManagerIntervalTimestamp(t *testing.T) {
+	core, rec := appinsightstest.NewCore("metrics-test")
+	before := time.Now()
+	mgr := core.NewMetricManager(&ait.MetricManagerOptions{Interval: time.Hour})
+	after := time.Now()
+	defer mgr.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	mgr.Track("orders", 1, nil)
+	flushed := time.Now()
+	mgr.Flush()
+	first := requireAggregate(t, rec, "orders", "")
+	if first.Timestamp.Before(before) || first.Timestamp.After(after) {
+		t.Errorf("timestamp %v, expected the creation of the manager", first.Timestamp)
+	}
+
+	// the next interval starts with the flush
+	rec.Reset()
+	time.Sleep(10 * time.Millisecond)
+	tracked := time.Now()
+	mgr.Track("orders", 1, nil)
+	mgr.Flush()
+	second := requireAggregate(t, rec, "orders", "")
+	if second.Timestamp.Before(flushed) || second.Timestamp.After(tracked) {
+		t.Errorf("timestamp %v, expected the previous flush %v", second.Timestamp, flushed)
+	}
+}
+
+func TestMetricManagerClose(t *testing.T) {
+	core, rec := appinsightstest.NewCore("metrics-test")
+	mgr := core.NewMetricManager(&ait.MetricManagerOptions{Interval: time.Hour})
+	mgr.Track("orders", 1, nil)
+	mgr.Close()
+	rec.RequireCount(t, 1)
+
+	if mgr.Track("orders", 1, nil) {
+		t.Errorf("value tracked after Close kept")
+	}
+	mgr.Close()
+	mgr.Flush()
+	rec.RequireCount(t, 1)
+}
+
+func TestTrackMetric(t *testing.T) {
+	core, rec := appinsightstest.NewCore("metrics-test")
+	tid, sid := ait.NewTraceId(), ait.NewSpanId()
+	ctx := ait.ContextWithTraceInfo(
+		context.Background(),
+		ait.TraceInfo{TraceId: tid, SpanId: sid},
+	)
+	fields := map[string]string{"queue": "orders"}
+	core.TrackMetric(ctx, "queueLength", 42, fields)
+
+	rec.RequireCount(t, 1)
+	metric := rec.Metrics()[0]
+	if metric.Name != "queueLength" || metric.Value != 42 || metric.Count != 0 {
+		t.Errorf("unexpected metric %+v", metric)
+	}
+	if metric.OperationId != tid || metric.ParentId != sid || metric.Properties["queue"] != "orders" {
+		t.Errorf("unexpected metric base %+v", metric.TelemetryBase)
+	}
+}
@@ -1,6 +1,71 @@
 package appinsightstrace
 
+import (
+	"time"
+)
+
+const (
+	// Exporter sending the telemetry to Application Insights
+	ExporterAppInsights = "appinsights"
+	// Exporter writing the telemetry as JSON lines to stdout
+	ExporterConsole = "console"
+)
+
+// Options for NewAppInsightsCore, can be loaded from the environment and a
+// YAML file with LoadOptions
+//
+// InstrumentationKey: the instrumentation key of the Application Insights
+//
+//	resource, ignored if ConnectionString is provided
+//
+// ConnectionString: the connection string of the Application Insights
+//
+//	resource, recommended over InstrumentationKey since it also configures the
+//	ingestion endpoint (regional, sovereign cloud or private link endpoints)
+//
+// ServiceName: the name of the service, used as the cloud role
+// MaxBatchSize: the maximum number of telemetry items sent to Application
+//
+//	Insights in a single request, defaults to the SDK default (1024)
+//
+// MaxBatchInterval: the maximum time telemetry is queued before being sent to
+//
+//	Application Insights, defaults to the SDK default (10s)
+//
+// SamplingPercentage: the percentage (0, 100] of operations kept by a
+//
+//	FixedRateSampler, zero or 100 disables sampling
+//
+// MaxItemsPerSecond: enables adaptive sampling with an AdaptiveSampler
+//
+//	targeting the rate of items per second per telemetry type, can't be
+//	combined with SamplingPercentage
+//
+// TailSampling: enables tail sampling in front of the exporters, check
+//
+//	TailSamplingExporter
+//
+// Redaction: enables the redaction of personal data and secrets, check
+//
+//	Redactor
+//
+// DependencyData: enables the sanitization and truncation of the data of
+//
+//	dependencies, check NewDependencyDataProcessor
+//
+// Exporters: the exporters the telemetry is sent to (ExporterAppInsights,
+//
+//	ExporterConsole), defaults to ExporterAppInsights only
 type AppInsightsOptions struct {
-	InstrumentationKey string
-	ServiceName        string
+	InstrumentationKey string                 `yaml:"instrumentationKey"`
+	ConnectionString   string                 `yaml:"connectionString"`
+	ServiceName        string                 `yaml:"serviceName"`
+	MaxBatchSize       int                    `yaml:"maxBatchSize"`
+	MaxBatchInterval   time.Duration          `yaml:"maxBatchInterval"`
+	SamplingPercentage float64                `yaml:"samplingPercentage"`
+	MaxItemsPerSecond  float64                `yaml:"maxItemsPerSecond"`
+	TailSampling       *TailSamplingOptions   `yaml:"tailSampling"`
+	Redaction          *RedactionOptions      `yaml:"redaction"`
+	DependencyData     *DependencyDataOptions `yaml:"dependencyData"`
+	Exporters          []string               `yaml:"exporters"`
 }
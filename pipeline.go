@@ -0,0 +1,132 @@
+package appinsightstrace
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Implement this to enrich every telemetry item emitted by AppInsightsCore
+// (ex: adding tags or properties), check AddInitializer
+type ITelemetryInitializer interface {
+	// Adds data to the item, must be safe to be called concurrently
+	Initialize(item ITelemetry)
+}
+
+// Implement this to modify or filter every telemetry item emitted by
+// AppInsightsCore, check AddProcessor
+type ITelemetryProcessor interface {
+	// Modifies the item and returns whether it should be kept, must be safe
+	// to be called concurrently
+	Process(item ITelemetry) bool
+}
+
+// Function implementing ITelemetryInitializer
+type TelemetryInitializerFunc func(item ITelemetry)
+
+func (fn TelemetryInitializerFunc) Initialize(item ITelemetry) {
+	fn(item)
+}
+
+// Function implementing ITelemetryProcessor
+type TelemetryProcessorFunc func(item ITelemetry) bool
+
+func (fn TelemetryProcessorFunc) Process(item ITelemetry) bool {
+	return fn(item)
+}
+
+// Appends an initializer to the pipeline, every item goes through all of the
+// initializers in the order they were added, then through the processors and
+// finally the sampler before being exported. Must be called before any
+// telemetry is traced
+func (ins *AppInsightsCore) AddInitializer(initializer ITelemetryInitializer) {
+	ins.initializers = append(ins.initializers, initializer)
+}
+
+// Appends a processor to the pipeline, processors run in the order they were
+// added after the initializers, an item dropped by a processor is not passed
+// to the following ones. Must be called before any telemetry is traced
+func (ins *AppInsightsCore) AddProcessor(processor ITelemetryProcessor) {
+	ins.processors = append(ins.processors, processor)
+}
+
+// Runs the item through the initializers and processors, returns whether it
+// should be kept
+func (ins *AppInsightsCore) process(item ITelemetry) bool {
+	for _, initializer := range ins.initializers {
+		initializer.Initialize(item)
+	}
+	for _, processor := range ins.processors {
+		if !processor.Process(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// - Built-ins
+
+// Default paths dropped by the HealthCheckFilter
+var DefaultHealthCheckPaths = []string{
+	"/health",
+	"/healthz",
+	"/livez",
+	"/readyz",
+	"/ready",
+	"/ping",
+}
+
+// Constructs a processor dropping the request telemetry of health checks
+// (requests whose url path matches one of the paths, case insensitive),
+// DefaultHealthCheckPaths are used if none are provided. The telemetry
+// emitted while handling the request is not dropped
+func NewHealthCheckFilter(paths ...string) ITelemetryProcessor {
+	if len(paths) == 0 {
+		paths = DefaultHealthCheckPaths
+	}
+	lookup := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		lookup[strings.ToLower(strings.TrimRight(path, "/"))] = struct{}{}
+	}
+	return TelemetryProcessorFunc(func(item ITelemetry) bool {
+		req, ok := item.(*RequestTelemetry)
+		if !ok {
+			return true
+		}
+		path := req.Url
+		if parsed, err := url.Parse(req.Url); err == nil {
+			path = parsed.Path
+		}
+		_, isHealthCheck := lookup[strings.ToLower(strings.TrimRight(path, "/"))]
+		return !isHealthCheck
+	})
+}
+
+// Constructs an initializer setting the application version tag
+// (ai.application.ver) on every item
+func NewAppVersionInitializer(version string) ITelemetryInitializer {
+	return TelemetryInitializerFunc(func(item ITelemetry) {
+		base := item.Base()
+		if base.Tags == nil {
+			base.Tags = make(map[string]string)
+		}
+		base.Tags[contracts.ApplicationVersion] = version
+	})
+}
+
+// Constructs an initializer adding the properties to every item, properties
+// already set on the item are not overwritten
+func NewPropertiesInitializer(props map[string]string) ITelemetryInitializer {
+	return TelemetryInitializerFunc(func(item ITelemetry) {
+		base := item.Base()
+		if base.Properties == nil {
+			base.Properties = make(map[string]string, len(props))
+		}
+		for key, val := range props {
+			if _, ok := base.Properties[key]; !ok {
+				base.Properties[key] = val
+			}
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package appinsightstrace_test
+
+import (
+	"sync"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Keeps every item, recording the names of the items it was asked about
+type recordingSampler struct {
+	mtx     sync.Mutex
+	sampled []string
+}
+
+func (s *recordingSampler) Sample(item ait.ITelemetry) (bool, float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sampled = append(s.sampled, appinsightstest.ItemName(item))
+	return true, 100
+}
+
+func TestHealthCheckFilter(t *testing.T) {
+	core, rec := appinsightstest.NewCore("pipeline-test")
+	sampler := &recordingSampler{}
+	core.UseSampler(sampler)
+	core.AddProcessor(ait.NewHealthCheckFilter())
+	ctx, _ := newTracedContext()
+
+	for _, url := range []string{
+		"/healthz",
+		"/Health/",
+		"https://orders.internal/readyz?full=1",
+		"/orders",
+		"/health/details",
+	} {
+		core.TraceRequestInfo(ctx, ait.RequestInfo{
+			Name:       "GET " + url,
+			Url:        url,
+			StatusCode: 200,
+		})
+	}
+	core.TraceDependencyInfo(ctx, ait.DependencyInfo{
+		Type:    "postgres",
+		Name:    "SELECT 1",
+		Success: true,
+	})
+
+	expected := []string{"GET /orders", "GET /health/details", "SELECT 1"}
+	if len(sampler.sampled) != len(expected) {
+		t.Fatalf("sampled %v, expected %v", sampler.sampled, expected)
+	}
+	for i, name := range expected {
+		if sampler.sampled[i] != name {
+			t.Errorf("sampled %v, expected %v", sampler.sampled, expected)
+		}
+	}
+	rec.RequireCount(t, len(expected))
+	for _, name := range expected {
+		if name == "SELECT 1" {
+			rec.RequireDependency(t, name)
+		} else {
+			rec.RequireRequest(t, name)
+		}
+	}
+
+	core, rec = appinsightstest.NewCore("pipeline-test")
+	core.AddProcessor(ait.NewHealthCheckFilter("/status/"))
+	for _, url := range []string{"/status", "/healthz"} {
+		core.TraceRequestInfo(ctx, ait.RequestInfo{Name: "GET " + url, Url: url})
+	}
+	rec.RequireCount(t, 1)
+	rec.RequireRequest(t, "GET /healthz")
+}
+
+func TestAppVersionInitializer(t *testing.T) {
+	core, rec := appinsightstest.NewCore("pipeline-test")
+	core.AddInitializer(ait.NewAppVersionInitializer("1.4.2"))
+	ctx, _ := newTracedContext()
+
+	core.TraceRequestInfo(ctx, ait.RequestInfo{
+		Name: "GET /orders",
+		Tags: map[string]string{contracts.UserId: "user"},
+	})
+	core.TraceLog(ctx, "loaded", ait.Information, nil)
+	core.TrackCustomEvent(ctx, "OrderPlaced", nil, nil)
+
+	rec.RequireCount(t, 3)
+	for _, item := range rec.Items() {
+		if ver := item.Base().Tags[contracts.ApplicationVersion]; ver != "1.4.2" {
+			t.Errorf("%s: application version %q", appinsightstest.ItemName(item), ver)
+		}
+	}
+	if tags := rec.RequireRequest(t, "GET /orders").Tags; tags[contracts.UserId] != "user" {
+		t.Errorf("existing tags lost %v", tags)
+	}
+
+	item := &ait.TraceTelemetry{Message: "no tags"}
+	ait.NewAppVersionInitializer("1.4.2").Initialize(item)
+	if item.Tags[contracts.ApplicationVersion] != "1.4.2" {
+		t.Errorf("tags not created %v", item.Tags)
+	}
+}
+
+func TestPropertiesInitializer(t *testing.T) {
+	core, rec := appinsightstest.NewCore("pipeline-test")
+	props := map[string]string{"region": "westeurope", "env": "prod"}
+	core.AddInitializer(ait.NewPropertiesInitializer(props))
+	ctx, _ := newTracedContext()
+
+	fields := map[string]string{"env": "staging"}
+	core.TraceLog(ctx, "with fields", ait.Information, fields)
+	core.TraceLog(ctx, "without fields", ait.Information, nil)
+
+	log := rec.RequireTrace(t, "with fields")
+	if log.Properties["env"] != "staging" || log.Properties["region"] != "westeurope" {
+		t.Errorf("unexpected properties %v", log.Properties)
+	}
+	log = rec.RequireTrace(t, "without fields")
+	if log.Properties["env"] != "prod" || log.Properties["region"] != "westeurope" {
+		t.Errorf("unexpected properties %v", log.Properties)
+	}
+	if len(fields) != 1 || len(props) != 2 || props["env"] != "prod" {
+		t.Errorf("maps of the caller modified %v %v", fields, props)
+	}
+
+	item := &ait.EventTelemetry{Name: "no properties"}
+	ait.NewPropertiesInitializer(props).Initialize(item)
+	if len(item.Properties) != 2 {
+		t.Errorf("properties not created %v", item.Properties)
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	core, rec := appinsightstest.NewCore("pipeline-test")
+	sampler := &recordingSampler{}
+	core.UseSampler(sampler)
+
+	step := func(name string) func(ait.ITelemetry) {
+		return func(item ait.ITelemetry) {
+			item.Base().Properties["steps"] += name + ";"
+		}
+	}
+	core.AddInitializer(ait.TelemetryInitializerFunc(step("first")))
+	core.AddInitializer(ait.NewPropertiesInitializer(map[string]string{
+		"steps": "ignored;",
+	}))
+	core.AddInitializer(ait.TelemetryInitializerFunc(step("second")))
+	core.AddProcessor(ait.TelemetryProcessorFunc(func(item ait.ITelemetry) bool {
+		step("processor")(item)
+		_, isTrace := item.(*ait.TraceTelemetry)
+		return !isTrace
+	}))
+	lastRuns := 0
+	core.AddProcessor(ait.TelemetryProcessorFunc(func(item ait.ITelemetry) bool {
+		lastRuns++
+		step("last")(item)
+		return true
+	}))
+	ctx, _ := newTracedContext()
+
+	core.TraceLog(ctx, "dropped", ait.Information, nil)
+	core.TrackCustomEvent(ctx, "kept", nil, nil)
+
+	rec.RequireCount(t, 1)
+	event := rec.RequireEvent(t, "kept")
+	if steps := event.Properties["steps"]; steps != "first;second;processor;last;" {
+		t.Errorf("unexpected steps %q", steps)
+	}
+	if lastRuns != 1 {
+		t.Errorf("processor after the dropping one ran %d times", lastRuns)
+	}
+	if len(sampler.sampled) != 1 || sampler.sampled[0] != "kept" {
+		t.Errorf("sampled %v, expected only the kept event", sampler.sampled)
+	}
+	if event.SampleRate != 100 {
+		t.Errorf("sample rate %v", event.SampleRate)
+	}
+}
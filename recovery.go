@@ -0,0 +1,124 @@
+package appinsightstrace
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Maximum number of frames recorded for the stack of a panic
+const maxPanicStackDepth = 64
+
+// Options for the panic recovery
+//
+// RePanic: whether the panic should be propagated once it has been traced,
+//
+//	the panic is swallowed otherwise
+//
+// Flush: whether the telemetry should be flushed once the panic has been
+//
+//	traced, so it is sent before the process crashes when RePanic is set
+type RecoveryOptions struct {
+	RePanic bool
+	Flush   bool
+}
+
+// Recovers a panic and transmits an exception telemetry (Critical severity)
+// with the panic value and the stack at the panic site, the ids are extracted
+// from the context with ITraceExtractor. Must be deferred directly, a nil
+// optn swallows the panic without flushing
+//
+//	defer tracer.Recover(ctx, &appInsightsTrace.RecoveryOptions{RePanic: true})
+func (ins *AppInsightsCore) Recover(
+	ctx context.Context,
+	optn *RecoveryOptions,
+) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	ins.tracePanic(ctx, recovered)
+	ins.endPanic(recovered, optn)
+}
+
+// Runs fn in a new goroutine, panics in fn are recovered and traced, check
+// Recover, and swallowed
+func (ins *AppInsightsCore) Go(
+	ctx context.Context,
+	fn func(ctx context.Context),
+) {
+	ins.GoWithOptions(ctx, fn, nil)
+}
+
+// Same as Go but with options, check documentation of RecoveryOptions for
+// more information
+func (ins *AppInsightsCore) GoWithOptions(
+	ctx context.Context,
+	fn func(ctx context.Context),
+	optn *RecoveryOptions,
+) {
+	go func() {
+		defer ins.Recover(ctx, optn)
+		fn(ctx)
+	}()
+}
+
+// Transmits the exception telemetry of the panic, must be called by the
+// deferred function recovering the panic so the panic site is on the stack
+func (ins *AppInsightsCore) tracePanic(
+	ctx context.Context,
+	recovered interface{},
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	severityLevel := Critical
+	ins.traceExceptionInfo(ExceptionInfo{
+		TraceId:       tid,
+		ParentId:      rid,
+		Error:         recovered,
+		SeverityLevel: &severityLevel,
+	}, panicStackFrames())
+}
+
+// Flushes the telemetry and propagates the panic as configured
+func (ins *AppInsightsCore) endPanic(
+	recovered interface{},
+	optn *RecoveryOptions,
+) {
+	if optn == nil {
+		return
+	}
+	if optn.Flush {
+		ins.Flush()
+	}
+	if optn.RePanic {
+		panic(recovered)
+	}
+}
+
+// Gets the stack starting at the function that panicked, the frames of the
+// recovery and of the runtime panic handling are removed
+func panicStackFrames() []*contracts.StackFrame {
+	pcs := make([]uintptr, maxPanicStackDepth)
+	frames := callersStackFrames(pcs[:runtime.Callers(2, pcs)])
+	for i, frame := range frames {
+		if frame.Assembly != "runtime" || frame.Method != "gopanic" {
+			continue
+		}
+		// runtime frames raising the panic (ex: runtime.panicmem for nil
+		// pointer dereferences) are skipped as well
+		site := i + 1
+		for site < len(frames) && frames[site].Assembly == "runtime" {
+			site++
+		}
+		if site == len(frames) {
+			break
+		}
+		frames = frames[site:]
+		for level, frame := range frames {
+			frame.Level = level
+		}
+		return frames
+	}
+	return frames
+}
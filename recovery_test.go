@@ -0,0 +1,205 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+// Recorder counting the flushes and signaling every exported item
+type flushRecorder struct {
+	*appinsightstest.Recorder
+	flushes  int
+	exported chan struct{}
+}
+
+func newFlushRecorder() *flushRecorder {
+	return &flushRecorder{
+		Recorder: appinsightstest.NewRecorder(),
+		exported: make(chan struct{}, 16),
+	}
+}
+
+func (rec *flushRecorder) Export(item ait.ITelemetry) {
+	rec.Recorder.Export(item)
+	rec.exported <- struct{}{}
+}
+
+func (rec *flushRecorder) Flush() {
+	rec.flushes++
+}
+
+func panicWithValue() {
+	panic("boom")
+}
+
+func panicWithNilPointer() {
+	var info *ait.TraceInfo
+	_ = info.TraceId
+}
+
+// Runs fn recovering its panic with the options, returns the value of the
+// propagated panic if any
+func recoverPanic(
+	core *ait.AppInsightsCore,
+	ctx context.Context,
+	optn *ait.RecoveryOptions,
+	fn func(),
+) (propagated interface{}) {
+	defer func() {
+		propagated = recover()
+	}()
+	func() {
+		defer core.Recover(ctx, optn)
+		fn()
+	}()
+	return nil
+}
+
+func TestRecoverPanicSite(t *testing.T) {
+	cases := map[string]struct {
+		fn       func()
+		function string
+		message  string
+	}{
+		"panic":       {panicWithValue, "panicWithValue", "boom"},
+		"nil pointer": {panicWithNilPointer, "panicWithNilPointer", "nil pointer"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			core, rec := appinsightstest.NewCore("recovery-test")
+			tid, sid := ait.NewTraceId(), ait.NewSpanId()
+			ctx := ait.ContextWithTraceInfo(
+				context.Background(),
+				ait.TraceInfo{TraceId: tid, SpanId: sid},
+			)
+			if propagated := recoverPanic(core, ctx, nil, c.fn); propagated != nil {
+				t.Fatalf("panic propagated without RePanic: %v", propagated)
+			}
+
+			ex := rec.RequireException(t)
+			if ex.SeverityLevel != ait.Critical {
+				t.Errorf("severity %v, expected Critical", ex.SeverityLevel)
+			}
+			if ex.OperationId != tid || ex.ParentId != sid {
+				t.Errorf("ids %s %s, expected %s %s", ex.OperationId, ex.ParentId, tid, sid)
+			}
+			if name := appinsightstest.ItemName(ex); !strings.Contains(name, c.message) {
+				t.Errorf("message %q, expected %q", name, c.message)
+			}
+			if len(ex.Frames) == 0 ||
+				!strings.HasSuffix(ex.Frames[0].Method, c.function) ||
+				ex.Frames[0].Level != 0 {
+				t.Fatalf("expected the panic site %s on top, got %+v", c.function, ex.Frames[0])
+			}
+		})
+	}
+}
+
+func TestRecoverOptions(t *testing.T) {
+	rec := newFlushRecorder()
+	core := ait.NewAppInsightsCoreWithExporter("recovery-test", rec, nil)
+
+	propagated := recoverPanic(core, context.Background(), &ait.RecoveryOptions{
+		RePanic: true,
+		Flush:   true,
+	}, panicWithValue)
+	if propagated != "boom" {
+		t.Errorf("propagated %v, expected boom", propagated)
+	}
+	if rec.flushes != 1 {
+		t.Errorf("flushed %d times, expected 1", rec.flushes)
+	}
+	rec.RequireCount(t, 1)
+
+	// no panic, nothing traced
+	if propagated := recoverPanic(core, context.Background(), nil, func() {}); propagated != nil {
+		t.Errorf("unexpected panic %v", propagated)
+	}
+	rec.RequireCount(t, 1)
+}
+
+func TestGoRecovers(t *testing.T) {
+	rec := newFlushRecorder()
+	core := ait.NewAppInsightsCoreWithExporter("recovery-test", rec, nil)
+	tid := ait.NewTraceId()
+	ctx := ait.ContextWithTraceInfo(
+		context.Background(),
+		ait.TraceInfo{TraceId: tid, SpanId: ait.NewSpanId()},
+	)
+
+	core.Go(ctx, func(ctx context.Context) {
+		panicWithValue()
+	})
+	select {
+	case <-rec.exported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic of the goroutine not traced")
+	}
+	ex := rec.RequireException(t)
+	if ex.OperationId != tid || !strings.HasSuffix(ex.Frames[0].Method, "panicWithValue") {
+		t.Errorf("unexpected exception %s %+v", ex.OperationId, ex.Frames[0])
+	}
+}
+
+func TestHttpMiddlewareRecovery(t *testing.T) {
+	core, rec := appinsightstest.NewCore("recovery-test")
+	handler := core.HttpMiddlewareWithOptions(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panicWithValue()
+		}),
+		&ait.HttpMiddlewareOptions{Recovery: &ait.RecoveryOptions{}},
+	)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/orders", nil))
+
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, expected 500", resp.Code)
+	}
+	req := rec.RequireRequest(t, "GET /orders")
+	if req.ResponseCode != "500" || req.Success {
+		t.Errorf("request %s success %v, expected a failed 500", req.ResponseCode, req.Success)
+	}
+	ex := rec.RequireException(t)
+	if ex.SeverityLevel != ait.Critical ||
+		ex.OperationId != req.OperationId ||
+		ex.ParentId != req.Id {
+		t.Errorf("exception not linked to the request %+v", ex.TelemetryBase)
+	}
+	if !strings.HasSuffix(ex.Frames[0].Method, "panicWithValue") {
+		t.Errorf("expected the panic site on top, got %+v", ex.Frames[0])
+	}
+}
+
+func TestHttpMiddlewareAbortHandler(t *testing.T) {
+	core, rec := appinsightstest.NewCore("recovery-test")
+	handler := core.HttpMiddlewareWithOptions(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}),
+		&ait.HttpMiddlewareOptions{Recovery: &ait.RecoveryOptions{}},
+	)
+
+	var propagated interface{}
+	func() {
+		defer func() {
+			propagated = recover()
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream", nil))
+	}()
+	if propagated != http.ErrAbortHandler {
+		t.Errorf("propagated %v, expected http.ErrAbortHandler", propagated)
+	}
+	if len(rec.Exceptions()) != 0 {
+		t.Errorf("aborting the handler traced as an exception")
+	}
+	if req := rec.RequireRequest(t, "GET /stream"); req.ResponseCode != "500" {
+		t.Errorf("response code %s, expected 500", req.ResponseCode)
+	}
+}
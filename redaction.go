@@ -0,0 +1,341 @@
+package appinsightstrace
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Default value replacing redacted data
+const DefaultRedactionMask = "[REDACTED]"
+
+// Default property names whose values are always redacted, names are
+// compared case insensitive and ignoring '-', '_' and '.'
+var DefaultDenyProperties = []string{
+	"password",
+	"passwd",
+	"pwd",
+	"secret",
+	"clientsecret",
+	"token",
+	"accesstoken",
+	"refreshtoken",
+	"idtoken",
+	"authorization",
+	"cookie",
+	"setcookie",
+	"apikey",
+	"xapikey",
+}
+
+// Default url query parameters whose values are always redacted, names are
+// compared case insensitive
+var DefaultDenyQueryParameters = []string{
+	"password",
+	"token",
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"code",
+	"secret",
+	"client_secret",
+	"apikey",
+	"api_key",
+	"key",
+	"sig",
+	"signature",
+}
+
+// Default value patterns redacted from any text (emails, card numbers,
+// bearer tokens and JWTs), card numbers are only redacted if they pass the
+// Luhn check
+var DefaultRedactionPatterns = []string{
+	`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`,
+	`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`,
+}
+
+var cardNumberPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+
+// Candidates for ip addresses in text, validated with net.ParseIP
+var (
+	ipv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern = regexp.MustCompile(`(?i)[0-9a-f]*:[0-9a-f]*:[0-9a-f:.]*`)
+)
+
+// Options for the redactor
+//
+// DenyProperties: additional property names whose values are redacted
+// DenyQueryParameters: additional url query parameters whose values are
+//
+//	redacted, "*" redacts all of them
+//
+// Patterns: additional regular expressions redacted from any text
+// DisableDefaults: whether the default property names, query parameters and
+//
+//	patterns should not be used
+//
+// AnonymizeIp: whether ip addresses in properties, tags and any redacted
+//
+//	text (names, urls, dependency data and targets, log and exception
+//	messages) should be anonymized, zeroing the last octet of IPv4 and the
+//	last 80 bits of IPv6 addresses
+//
+// Mask: the value replacing redacted data, defaults to DefaultRedactionMask
+type RedactionOptions struct {
+	DenyProperties      []string `yaml:"denyProperties"`
+	DenyQueryParameters []string `yaml:"denyQueryParameters"`
+	Patterns            []string `yaml:"patterns"`
+	DisableDefaults     bool     `yaml:"disableDefaults"`
+	AnonymizeIp         bool     `yaml:"anonymizeIp"`
+	Mask                string   `yaml:"mask"`
+}
+
+// ITelemetryProcessor removing personal data and secrets from the telemetry,
+// it redacts the values of denied properties and query parameters, the
+// matches of the patterns in names, urls, dependency data, log messages,
+// exception messages and property values, and optionally anonymizes ip
+// addresses (including the dependency targets). Add it with AddProcessor
+// after any processor or initializer adding data that should be redacted
+type Redactor struct {
+	denyProperties map[string]struct{}
+	denyQuery      map[string]struct{}
+	denyAllQuery   bool
+	patterns       []*regexp.Regexp
+	cardNumbers    bool
+	anonymizeIp    bool
+	mask           string
+}
+
+var _ ITelemetryProcessor = (*Redactor)(nil)
+
+// Constructs a Redactor, an error is returned if any of the patterns is not a
+// valid regular expression
+func NewRedactor(optn *RedactionOptions) (*Redactor, error) {
+	if optn == nil {
+		optn = &RedactionOptions{}
+	}
+	r := &Redactor{
+		denyProperties: map[string]struct{}{},
+		denyQuery:      map[string]struct{}{},
+		cardNumbers:    !optn.DisableDefaults,
+		anonymizeIp:    optn.AnonymizeIp,
+		mask:           optn.Mask,
+	}
+	if r.mask == "" {
+		r.mask = DefaultRedactionMask
+	}
+
+	denyProperties := optn.DenyProperties
+	denyQuery := optn.DenyQueryParameters
+	patterns := optn.Patterns
+	if !optn.DisableDefaults {
+		denyProperties = append(denyProperties, DefaultDenyProperties...)
+		denyQuery = append(denyQuery, DefaultDenyQueryParameters...)
+		patterns = append(patterns, DefaultRedactionPatterns...)
+	}
+	for _, name := range denyProperties {
+		r.denyProperties[normalizePropertyName(name)] = struct{}{}
+	}
+	for _, name := range denyQuery {
+		if name == "*" {
+			r.denyAllQuery = true
+		}
+		r.denyQuery[strings.ToLower(name)] = struct{}{}
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func (r *Redactor) Process(item ITelemetry) bool {
+	switch tele := item.(type) {
+	case *RequestTelemetry:
+		tele.Name = r.RedactString(tele.Name)
+		tele.Url = r.RedactUrl(tele.Url)
+	case *PageViewTelemetry:
+		tele.Name = r.RedactString(tele.Name)
+		tele.Url = r.RedactUrl(tele.Url)
+	case *DependencyTelemetry:
+		tele.Name = r.RedactString(tele.Name)
+		tele.Target = r.RedactString(tele.Target)
+		if strings.Contains(tele.Data, "://") {
+			tele.Data = r.RedactUrl(tele.Data)
+		} else {
+			tele.Data = r.RedactString(tele.Data)
+		}
+	case *TraceTelemetry:
+		tele.Message = r.RedactString(tele.Message)
+	case *ExceptionTelemetry:
+		message := tele.Message
+		if message == "" {
+			message = exceptionMessage(tele.Error)
+		}
+		if redacted := r.RedactString(message); redacted != message {
+			tele.Message = redacted
+		}
+		for _, inner := range tele.InnerExceptions {
+			inner.Message = r.RedactString(inner.Message)
+		}
+	case *EventTelemetry:
+		tele.Name = r.RedactString(tele.Name)
+	}
+
+	base := item.Base()
+	for key, val := range base.Properties {
+		base.Properties[key] = r.redactProperty(key, val)
+	}
+	if ip, ok := base.Tags[contracts.LocationIp]; ok && r.anonymizeIp {
+		base.Tags[contracts.LocationIp] = AnonymizeIp(ip)
+	}
+	return true
+}
+
+// Redacts the matches of the patterns from the text and anonymizes the ip
+// addresses it contains if enabled
+func (r *Redactor) RedactString(text string) string {
+	if text == "" {
+		return text
+	}
+	for _, re := range r.patterns {
+		text = re.ReplaceAllLiteralString(text, r.mask)
+	}
+	if r.cardNumbers {
+		text = cardNumberPattern.ReplaceAllStringFunc(text, func(match string) string {
+			if isLuhnValid(match) {
+				return r.mask
+			}
+			return match
+		})
+	}
+	if r.anonymizeIp {
+		text = anonymizeIps(text)
+	}
+	return text
+}
+
+// Redacts the values of the denied query parameters and the matches of the
+// patterns from the url, the rest of the url is left as is
+func (r *Redactor) RedactUrl(rawUrl string) string {
+	idx := strings.IndexByte(rawUrl, '?')
+	if idx < 0 {
+		return r.RedactString(rawUrl)
+	}
+	query := rawUrl[idx+1:]
+	fragment := ""
+	if hash := strings.IndexByte(query, '#'); hash >= 0 {
+		query, fragment = query[:hash], query[hash:]
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+		if _, denied := r.denyQuery[strings.ToLower(name)]; denied || r.denyAllQuery {
+			params[i] = name + "=" + r.mask
+		}
+	}
+	return r.RedactString(rawUrl[:idx+1] + strings.Join(params, "&") + fragment)
+}
+
+func (r *Redactor) redactProperty(key string, val string) string {
+	if r.isDeniedProperty(key) {
+		return r.mask
+	}
+	if r.anonymizeIp && net.ParseIP(val) != nil {
+		return AnonymizeIp(val)
+	}
+	return r.RedactString(val)
+}
+
+// Matches the property name, or the last segment of dotted names (ex: slog
+// groups), against the deny list
+func (r *Redactor) isDeniedProperty(key string) bool {
+	if _, ok := r.denyProperties[normalizePropertyName(key)]; ok {
+		return true
+	}
+	if idx := strings.LastIndexByte(key, '.'); idx >= 0 {
+		_, ok := r.denyProperties[normalizePropertyName(key[idx+1:])]
+		return ok
+	}
+	return false
+}
+
+// Anonymizes the ip address by zeroing the last octet of IPv4 addresses and
+// the last 80 bits of IPv6 addresses, values that are not ip addresses are
+// returned as is
+func AnonymizeIp(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		v4[3] = 0
+		return v4.String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Anonymizes every ip address found in the text, check AnonymizeIp
+func anonymizeIps(text string) string {
+	anonymize := func(match string) string {
+		if net.ParseIP(match) == nil {
+			return match
+		}
+		return AnonymizeIp(match)
+	}
+	text = ipv6Pattern.ReplaceAllStringFunc(text, anonymize)
+	return ipv4Pattern.ReplaceAllStringFunc(text, anonymize)
+}
+
+func normalizePropertyName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", ".", "").Replace(name))
+}
+
+func isLuhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Gets the message of an exception the same way the Application Insights SDK
+// does
+func exceptionMessage(exception interface{}) string {
+	switch ex := exception.(type) {
+	case error:
+		return ex.Error()
+	case string:
+		return ex
+	case fmt.Stringer:
+		return ex.String()
+	case fmt.GoStringer:
+		return ex.GoString()
+	default:
+		return "<unknown>"
+	}
+}
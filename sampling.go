@@ -0,0 +1,104 @@
+package appinsightstrace
+
+import (
+	"math"
+	"math/rand"
+	"unicode/utf16"
+)
+
+// Implement this to decide which telemetry items are sent, check UseSampler.
+// Items of an operation should be kept or dropped together, which can be done
+// by deciding from SamplingScore of the operation id
+type ISampler interface {
+	// Decides whether the item is kept, along with the sampling percentage
+	// (0, 100] the kept item was sampled with, must be safe to be called
+	// concurrently
+	Sample(item ITelemetry) (keep bool, percentage float64)
+}
+
+// Sets the sampler deciding which telemetry items are sent, kept items carry
+// the sampling percentage as their SampleRate so Application Insights can
+// extrapolate the counts. Metrics are never sampled since they are already
+// aggregated. Must be called before any telemetry is traced, a nil sampler
+// disables sampling
+func (ins *AppInsightsCore) UseSampler(sampler ISampler) {
+	ins.sampler = sampler
+}
+
+// - Fixed rate
+
+// ISampler keeping a fixed percentage of the operations, compatible with the
+// fixed-rate sampling of the Application Insights SDKs so traces spanning
+// services instrumented in other languages are kept or dropped as a whole
+type FixedRateSampler struct {
+	percentage float64
+}
+
+var _ ISampler = (*FixedRateSampler)(nil)
+
+// Constructs a FixedRateSampler keeping the percentage [0, 100] of the
+// operations, the percentage is clamped to the range
+func NewFixedRateSampler(percentage float64) *FixedRateSampler {
+	return &FixedRateSampler{
+		percentage: clampPercentage(percentage),
+	}
+}
+
+func (s *FixedRateSampler) Sample(item ITelemetry) (bool, float64) {
+	return isSampledIn(item, s.percentage), s.percentage
+}
+
+// Gets the sampling percentage of the sampler
+func (s *FixedRateSampler) Percentage() float64 {
+	return s.percentage
+}
+
+// - Score
+
+// Computes the sampling score [0, 100] of the operation id with the algorithm
+// of the Application Insights SDKs (SamplingScoreGenerator), an item is kept
+// by a sampler at percentage p if its score is lower than p. A random score is
+// returned for empty operation ids
+func SamplingScore(operationId string) float64 {
+	if operationId == "" {
+		return rand.Float64() * 100
+	}
+	return float64(samplingHashCode(operationId)) / math.MaxInt32 * 100
+}
+
+// djb2 hash over the UTF-16 code units of the input repeated to at least 8
+// characters, using int32 arithmetic to match the .NET and JavaScript SDKs
+func samplingHashCode(input string) int32 {
+	units := utf16.Encode([]rune(input))
+	for len(units) < 8 {
+		units = append(units, units...)
+	}
+	hash := int32(5381)
+	for _, unit := range units {
+		hash = (hash << 5) + hash + int32(unit)
+	}
+	if hash == math.MinInt32 {
+		return math.MaxInt32
+	}
+	if hash < 0 {
+		return -hash
+	}
+	return hash
+}
+
+func isSampledIn(item ITelemetry, percentage float64) bool {
+	if percentage >= 100 {
+		return true
+	}
+	return SamplingScore(item.Base().OperationId) < percentage
+}
+
+func clampPercentage(percentage float64) float64 {
+	if percentage > 100 || math.IsNaN(percentage) {
+		return 100
+	}
+	if percentage < 0 {
+		return 0
+	}
+	return percentage
+}
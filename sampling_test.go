@@ -0,0 +1,57 @@
+package appinsightstrace_test
+
+import (
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+// Scores computed by the SamplingScoreGenerator of the .NET SDK and the
+// HashCodeScoreGenerator of the JavaScript SDK, both agree on every value
+var samplingScores = map[string]float64{
+	"4bf92f3577b34da6a3ce929d0e0e4736":                    33.46135385030012,
+	"0af7651916cd43dd8448eb211c80319c":                    52.78892803601405,
+	"|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7.": 69.46393934519214,
+	"operation-id": 45.58796661234832,
+	"abc":          46.12368808413096,
+	"a":            16.249091046046974,
+	"操作":           47.6754029037782,
+	"🙂x":           1.7387136359413684,
+}
+
+func TestSamplingScore(t *testing.T) {
+	for id, expected := range samplingScores {
+		if score := ait.SamplingScore(id); score != expected {
+			t.Errorf("SamplingScore(%q) = %v, expected %v", id, score, expected)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if score := ait.SamplingScore(""); score < 0 || score >= 100 {
+			t.Fatalf("random score %v out of [0, 100)", score)
+		}
+	}
+}
+
+func TestFixedRateSampler(t *testing.T) {
+	item := &ait.RequestTelemetry{
+		TelemetryBase: ait.TelemetryBase{OperationId: "a"},
+	}
+	cases := map[float64]bool{
+		100:   true,
+		150:   true,
+		16.25: true,
+		16.24: false,
+		0:     false,
+		-1:    false,
+	}
+	for percentage, expected := range cases {
+		s := ait.NewFixedRateSampler(percentage)
+		keep, rate := s.Sample(item)
+		if keep != expected || rate != s.Percentage() {
+			t.Errorf("at %v kept %v with %v, expected %v", percentage, keep, rate, expected)
+		}
+	}
+	if p := ait.NewFixedRateSampler(150).Percentage(); p != 100 {
+		t.Errorf("percentage %v, expected clamped to 100", p)
+	}
+}
@@ -0,0 +1,184 @@
+package appinsightstrace
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Options for the slog handler
+//
+// Level: the minimum level of records to be traced, defaults to Debug
+// AddSource: whether to include the source file and line of the log call as
+//
+//	the "source" custom value
+type SlogHandlerOptions struct {
+	Level     slog.Leveler
+	AddSource bool
+}
+
+// Constructs a slog.Handler that transmits every record as a trace log
+// telemetry, attributes are flattened into custom values with dotted keys for
+// groups (ex: request.method) and the context passed to the logger is used
+// with the ITraceExtractor to correlate the log with the current operation
+//
+//	lgr := slog.New(tracer.NewSlogHandler(nil))
+//	lgr.InfoContext(ctx, "processing order", "orderId", id)
+func (ins *AppInsightsCore) NewSlogHandler(
+	optn *SlogHandlerOptions,
+) slog.Handler {
+	if optn == nil {
+		optn = &SlogHandlerOptions{}
+	}
+	level := optn.Level
+	if level == nil {
+		level = slog.LevelDebug
+	}
+	return &slogHandler{
+		ins:       ins,
+		level:     level,
+		addSource: optn.AddSource,
+		attrs:     map[string]string{},
+	}
+}
+
+type slogHandler struct {
+	ins       *AppInsightsCore
+	level     slog.Leveler
+	addSource bool
+	// attributes added with WithAttrs, already flattened
+	attrs map[string]string
+	// prefix of the groups opened with WithGroup (ex: "request.")
+	prefix string
+}
+
+var _ slog.Handler = (*slogHandler)(nil)
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	props := make(map[string]string, len(h.attrs)+record.NumAttrs()+1)
+	for key, val := range h.attrs {
+		props[key] = val
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		flattenSlogAttr(props, h.prefix, attr)
+		return true
+	})
+	if h.addSource && record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		if frame.File != "" {
+			props["source"] = frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+	}
+
+	timestamp := record.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	_, tid, _, rid, _ := h.ins.traceExtractor.ExtractTraceInfo(ctx)
+	h.ins.TraceLogWithIds(
+		tid,
+		rid,
+		record.Message,
+		contracts.SeverityLevel(slogSeverityLevel(record.Level)),
+		timestamp,
+		props,
+	)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	clone := h.clone()
+	for _, attr := range attrs {
+		flattenSlogAttr(clone.attrs, clone.prefix, attr)
+	}
+	return clone
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := h.clone()
+	clone.prefix = clone.prefix + name + "."
+	return clone
+}
+
+func (h *slogHandler) clone() *slogHandler {
+	attrs := make(map[string]string, len(h.attrs))
+	for key, val := range h.attrs {
+		attrs[key] = val
+	}
+	return &slogHandler{
+		ins:       h.ins,
+		level:     h.level,
+		addSource: h.addSource,
+		attrs:     attrs,
+		prefix:    h.prefix,
+	}
+}
+
+// Flattens the attribute into the properties following the slog handler
+// rules, empty attributes are ignored, groups are flattened into dotted keys
+// and groups with an empty key are inlined
+func flattenSlogAttr(props map[string]string, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, ga := range attr.Value.Group() {
+			flattenSlogAttr(props, groupPrefix, ga)
+		}
+		return
+	}
+	props[prefix+attr.Key] = slogValueString(attr.Value)
+}
+
+func slogValueString(val slog.Value) string {
+	switch val.Kind() {
+	case slog.KindString:
+		return val.String()
+	case slog.KindTime:
+		return val.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := val.Any().(error); ok {
+			return err.Error()
+		}
+		return fmt.Sprint(val.Any())
+	default:
+		return val.String()
+	}
+}
+
+// Maps the slog level to the matching SeverityLevel, levels above Error
+// (custom levels) are mapped to Critical
+func slogSeverityLevel(level slog.Level) SeverityLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return Verbose
+	case level < slog.LevelWarn:
+		return Information
+	case level < slog.LevelError:
+		return Warning
+	case level == slog.LevelError:
+		return Error
+	default:
+		return Critical
+	}
+}
@@ -0,0 +1,141 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+func TestSlogHandlerFlattensAttributes(t *testing.T) {
+	core, rec := appinsightstest.NewCore("slog-test")
+	lgr := slog.New(core.NewSlogHandler(nil)).
+		With("service", "orders").
+		WithGroup("request").
+		With("method", "GET").
+		WithGroup("").
+		WithGroup("user")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	lgr.Info(
+		"handled",
+		"id", 42,
+		slog.Group("address", "city", "london", slog.Group("", "zip", "E1")),
+		slog.Group("empty"),
+		slog.Attr{},
+		"createdAt", created,
+		"err", errors.New("not found"),
+		"ratio", 0.5,
+	)
+	slog.New(core.NewSlogHandler(nil)).With().WithGroup("").Info("plain", "key", "value")
+
+	log := rec.RequireTrace(t, "handled")
+	expected := map[string]string{
+		"service":                   "orders",
+		"request.method":            "GET",
+		"request.user.id":           "42",
+		"request.user.address.city": "london",
+		"request.user.address.zip":  "E1",
+		"request.user.createdAt":    created.Format(time.RFC3339Nano),
+		"request.user.err":          "not found",
+		"request.user.ratio":        "0.5",
+	}
+	if len(log.Properties) != len(expected) {
+		t.Errorf("unexpected properties %v", log.Properties)
+	}
+	for key, val := range expected {
+		if log.Properties[key] != val {
+			t.Errorf("property %s = %q, expected %q", key, log.Properties[key], val)
+		}
+	}
+
+	plain := rec.RequireTrace(t, "plain")
+	if len(plain.Properties) != 1 || plain.Properties["key"] != "value" {
+		t.Errorf("unexpected properties %v", plain.Properties)
+	}
+}
+
+func TestSlogHandlerDoesNotShareAttributes(t *testing.T) {
+	core, rec := appinsightstest.NewCore("slog-test")
+	base := slog.New(core.NewSlogHandler(nil)).With("shared", "yes")
+	first := base.With("branch", "first")
+	second := base.WithGroup("g").With("branch", "second")
+
+	first.Info("first")
+	second.Info("second")
+	base.Info("base")
+
+	if props := rec.RequireTrace(t, "first").Properties; len(props) != 2 ||
+		props["branch"] != "first" {
+		t.Errorf("first %v", props)
+	}
+	if props := rec.RequireTrace(t, "second").Properties; len(props) != 2 ||
+		props["g.branch"] != "second" ||
+		props["shared"] != "yes" {
+		t.Errorf("second %v", props)
+	}
+	if props := rec.RequireTrace(t, "base").Properties; len(props) != 1 {
+		t.Errorf("base %v", props)
+	}
+}
+
+func TestSlogHandlerLevels(t *testing.T) {
+	core, rec := appinsightstest.NewCore("slog-test")
+	lgr := slog.New(core.NewSlogHandler(&ait.SlogHandlerOptions{
+		Level: slog.LevelDebug - 4,
+	}))
+	cases := map[slog.Level]ait.SeverityLevel{
+		slog.LevelDebug - 4: ait.Verbose,
+		slog.LevelDebug:     ait.Verbose,
+		slog.LevelInfo:      ait.Information,
+		slog.LevelInfo + 2:  ait.Information,
+		slog.LevelWarn:      ait.Warning,
+		slog.LevelError:     ait.Error,
+		slog.LevelError + 4: ait.Critical,
+	}
+	for level, severity := range cases {
+		message := "level [" + level.String() + "]"
+		lgr.Log(context.Background(), level, message)
+		if log := rec.RequireTrace(t, message); log.SeverityLevel != severity {
+			t.Errorf("%s traced as %v, expected %v", level, log.SeverityLevel, severity)
+		}
+	}
+
+	rec.Reset()
+	warn := slog.New(core.NewSlogHandler(&ait.SlogHandlerOptions{
+		Level: slog.LevelWarn,
+	}))
+	warn.Info("ignored")
+	warn.Warn("kept")
+	rec.RequireCount(t, 1)
+	rec.RequireTrace(t, "kept")
+
+	if slog.New(core.NewSlogHandler(nil)).Enabled(context.Background(), slog.LevelDebug-1) {
+		t.Errorf("levels below Debug enabled by default")
+	}
+}
+
+func TestSlogHandlerCorrelatesContext(t *testing.T) {
+	core, rec := appinsightstest.NewCore("slog-test")
+	lgr := slog.New(core.NewSlogHandler(&ait.SlogHandlerOptions{AddSource: true}))
+	ctx, info := newTracedContext()
+
+	lgr.InfoContext(ctx, "correlated")
+	lgr.Info("no trace")
+
+	log := rec.RequireTrace(t, "correlated")
+	if log.OperationId != info.TraceId || log.ParentId != info.SpanId {
+		t.Errorf("log %+v not correlated with %+v", log.TelemetryBase, info)
+	}
+	if source := log.Properties["source"]; !strings.Contains(source, "slogHandler_test.go:") {
+		t.Errorf("unexpected source %q", source)
+	}
+	if log := rec.RequireTrace(t, "no trace"); log.OperationId != "" {
+		t.Errorf("log correlated without trace information %+v", log.TelemetryBase)
+	}
+}
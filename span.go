@@ -0,0 +1,236 @@
+package appinsightstrace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The role of a span within the trace, determines the telemetry transmitted
+// when the span ends
+type SpanKind int
+
+const (
+	// An operation internal to the service, traced as an InProc dependency
+	SpanKindInternal SpanKind = 0
+	// An incoming request handled by the service, traced as a request
+	SpanKindServer SpanKind = 1
+	// An outgoing call made by the service, traced as a dependency
+	SpanKindClient SpanKind = 2
+	// A message sent by the service, traced as a dependency
+	SpanKindProducer SpanKind = 3
+	// A message received by the service, traced as a request
+	SpanKindConsumer SpanKind = 4
+)
+
+// A single operation within a trace, created with AppInsightsCore.StartSpan,
+// the telemetry for the span is transmitted when End is called. All methods
+// are safe to be used concurrently
+type Span struct {
+	ins   *AppInsightsCore
+	name  string
+	kind  SpanKind
+	info  TraceInfo
+	start time.Time
+
+	mtx            sync.Mutex
+	attributes     map[string]string
+	measurements   map[string]float64
+	success        bool
+	resultCode     string
+	dependencyType string
+	target         string
+	ended          bool
+}
+
+// Starts a new span as a child of the span carried by the context (a new
+// trace is started if there is none), the returned context carries the new
+// span so any telemetry traced with it (or spans started from it) are linked
+// to the span. The trace information of the context is read using the
+// ITraceExtractor and the returned context carries the span as a TraceInfo,
+// so it's recommended to use the W3CTraceExtractor with this function
+//
+// ctx: the current context of the execution
+// name: the name of the operation (ex: GET /api/v1/weather or ProcessOrder)
+// kind: the kind of the span, server and consumer spans are traced as
+//
+//	requests and client, producer and internal spans as dependencies
+func (ins *AppInsightsCore) StartSpan(
+	ctx context.Context,
+	name string,
+	kind SpanKind,
+) (context.Context, *Span) {
+	ver, tid, _, rid, flg := ins.traceExtractor.ExtractTraceInfo(ctx)
+	if !IsValidTraceId(tid) {
+		tid = NewTraceId()
+		rid = ""
+	}
+	if ver == "" {
+		ver = TraceParentVersion
+	}
+	if flg == "" {
+		flg = TraceFlagsSampled
+	}
+	info := TraceInfo{
+		Version:  ver,
+		TraceId:  tid,
+		ParentId: rid,
+		SpanId:   NewSpanId(),
+		Flags:    flg,
+	}
+	if parent, ok := TraceInfoFromContext(ctx); ok {
+		info.State = parent.State
+	}
+
+	dependencyType := ""
+	switch kind {
+	case SpanKindInternal:
+		dependencyType = "InProc"
+	case SpanKindProducer:
+		dependencyType = "Queue Message"
+	case SpanKindClient:
+		dependencyType = "Other"
+	}
+
+	span := &Span{
+		ins:            ins,
+		name:           name,
+		kind:           kind,
+		info:           info,
+		start:          time.Now(),
+		attributes:     map[string]string{},
+		measurements:   map[string]float64{},
+		success:        true,
+		dependencyType: dependencyType,
+	}
+	return ContextWithTraceInfo(ctx, info), span
+}
+
+// Returns the trace information of the span
+func (span *Span) TraceInfo() TraceInfo {
+	return span.info
+}
+
+// Returns the traceparent to be propagated to calls made on behalf of the span
+func (span *Span) TraceParent() string {
+	return TraceContext{
+		Version:  TraceParentVersion,
+		TraceId:  span.info.TraceId,
+		ParentId: span.info.SpanId,
+		Flags:    span.info.Flags,
+	}.TraceParent()
+}
+
+// Sets a custom value to be transmitted with the telemetry of the span, the
+// "url" attribute is also used as the url of request telemetry and the "data"
+// attribute as the data (ex: the full command) of dependency telemetry
+func (span *Span) SetAttribute(key string, value string) {
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	span.attributes[key] = value
+}
+
+// Sets a custom numeric value on the span (ex: rows returned, payload bytes)
+func (span *Span) SetMeasurement(key string, value float64) {
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	span.measurements[key] = value
+}
+
+// Sets whether the operation was successful and the result code (ex: the http
+// status code) of the operation
+func (span *Span) SetStatus(success bool, resultCode string) {
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	span.success = success
+	span.resultCode = resultCode
+}
+
+// Sets the type (ex: postgres, rabbitmq) and target (ex: the address of the
+// database) of the dependency, only used for client, producer and internal
+// spans
+func (span *Span) SetDependency(dependencyType string, target string) {
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	span.dependencyType = dependencyType
+	span.target = target
+}
+
+// Marks the span as failed and transmits a new exception telemetry for the
+// error, linked to the span
+func (span *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	span.mtx.Lock()
+	span.success = false
+	span.attributes["error"] = err.Error()
+	span.mtx.Unlock()
+
+	span.ins.TraceExceptionWithIds(
+		span.info.TraceId,
+		span.info.SpanId,
+		err,
+		1,
+		map[string]string{"spanName": span.name},
+	)
+}
+
+// Ends the span and transmits the telemetry for it, request telemetry for
+// server and consumer spans and dependency telemetry otherwise, calling End
+// more than once has no effect
+func (span *Span) End() {
+	end := time.Now()
+	span.mtx.Lock()
+	if span.ended {
+		span.mtx.Unlock()
+		return
+	}
+	span.ended = true
+	props := make(map[string]string, len(span.attributes))
+	for key, val := range span.attributes {
+		props[key] = val
+	}
+	measurements := make(map[string]float64, len(span.measurements))
+	for key, val := range span.measurements {
+		measurements[key] = val
+	}
+	success := span.success
+	resultCode := span.resultCode
+	dependencyType := span.dependencyType
+	target := span.target
+	span.mtx.Unlock()
+
+	switch span.kind {
+	case SpanKindServer, SpanKindConsumer:
+		span.ins.traceRequestInfo(RequestInfo{
+			TraceId:        span.info.TraceId,
+			ParentId:       span.info.ParentId,
+			RequestId:      span.info.SpanId,
+			Name:           span.name,
+			Url:            props["url"],
+			ResponseCode:   resultCode,
+			Success:        &success,
+			StartTimestamp: span.start,
+			EndTimestamp:   end,
+			Measurements:   measurements,
+			Fields:         props,
+		})
+	default:
+		span.ins.traceDependencyInfo(DependencyInfo{
+			TraceId:        span.info.TraceId,
+			ParentId:       span.info.ParentId,
+			SpanId:         span.info.SpanId,
+			Type:           dependencyType,
+			Target:         target,
+			Name:           span.name,
+			ResultCode:     resultCode,
+			Data:           props["data"],
+			Success:        success,
+			StartTimestamp: span.start,
+			EndTimestamp:   end,
+			Measurements:   measurements,
+			Fields:         props,
+		})
+	}
+}
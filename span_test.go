@@ -0,0 +1,193 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+func TestStartSpanLinksParent(t *testing.T) {
+	core, rec := appinsightstest.NewCore("span-test")
+
+	rootCtx, root := core.StartSpan(context.Background(), "GET /orders", ait.SpanKindServer)
+	childCtx, child := core.StartSpan(rootCtx, "LoadOrders", ait.SpanKindInternal)
+	_, grandchild := core.StartSpan(childCtx, "SELECT orders", ait.SpanKindClient)
+
+	rootInfo := root.TraceInfo()
+	if !ait.IsValidTraceId(rootInfo.TraceId) || !ait.IsValidSpanId(rootInfo.SpanId) {
+		t.Fatalf("invalid root ids %+v", rootInfo)
+	}
+	if rootInfo.ParentId != "" || rootInfo.Flags != ait.TraceFlagsSampled {
+		t.Errorf("unexpected root %+v", rootInfo)
+	}
+	childInfo := child.TraceInfo()
+	if childInfo.TraceId != rootInfo.TraceId || childInfo.ParentId != rootInfo.SpanId {
+		t.Errorf("child %+v not linked to root %+v", childInfo, rootInfo)
+	}
+	if childInfo.SpanId == rootInfo.SpanId {
+		t.Errorf("child reuses the span id of the root")
+	}
+	grandchildInfo := grandchild.TraceInfo()
+	if grandchildInfo.TraceId != rootInfo.TraceId ||
+		grandchildInfo.ParentId != childInfo.SpanId {
+		t.Errorf("grandchild %+v not linked to child %+v", grandchildInfo, childInfo)
+	}
+	if info, ok := ait.TraceInfoFromContext(childCtx); !ok || info != childInfo {
+		t.Errorf("context carries %+v, expected %+v", info, childInfo)
+	}
+
+	core.TraceLog(childCtx, "loading", ait.Information, nil)
+	grandchild.End()
+	child.End()
+	root.End()
+
+	operationId := rec.RequireSingleOperation(t)
+	if operationId != rootInfo.TraceId {
+		t.Errorf("operation %s, expected %s", operationId, rootInfo.TraceId)
+	}
+	req := rec.RequireRequest(t, "GET /orders")
+	if req.Id != rootInfo.SpanId || req.ParentId != "" {
+		t.Errorf("unexpected request ids %+v", req)
+	}
+	dep := rec.RequireDependencyUnder(t, req.Id)
+	if dep.Name != "LoadOrders" || dep.Id != childInfo.SpanId {
+		t.Errorf("unexpected dependency under the request %+v", dep)
+	}
+	if nested := rec.RequireDependencyUnder(t, dep.Id); nested.Name != "SELECT orders" {
+		t.Errorf("unexpected dependency under the child %+v", nested)
+	}
+	if log := rec.RequireTrace(t, "loading"); log.ParentId != childInfo.SpanId {
+		t.Errorf("log parent %s, expected the child span", log.ParentId)
+	}
+}
+
+func TestStartSpanContinuesIncomingTrace(t *testing.T) {
+	core, rec := appinsightstest.NewCore("span-test")
+	incoming := ait.TraceInfo{
+		Version:  "00",
+		TraceId:  ait.NewTraceId(),
+		ParentId: ait.NewSpanId(),
+		SpanId:   ait.NewSpanId(),
+		Flags:    "00",
+		State:    "vendor=value",
+	}
+	ctx := ait.ContextWithTraceInfo(context.Background(), incoming)
+
+	_, span := core.StartSpan(ctx, "ProcessOrder", ait.SpanKindConsumer)
+	info := span.TraceInfo()
+	if info.TraceId != incoming.TraceId || info.ParentId != incoming.SpanId {
+		t.Errorf("span %+v doesn't continue %+v", info, incoming)
+	}
+	if info.Flags != "00" || info.State != "vendor=value" {
+		t.Errorf("flags or state not propagated %+v", info)
+	}
+	expected := "00-" + info.TraceId + "-" + info.SpanId + "-00"
+	if parent := span.TraceParent(); parent != expected {
+		t.Errorf("traceparent %q, expected %q", parent, expected)
+	}
+
+	span.End()
+	req := rec.RequireRequest(t, "ProcessOrder")
+	if req.OperationId != incoming.TraceId ||
+		req.ParentId != incoming.SpanId ||
+		req.Id != info.SpanId {
+		t.Errorf("unexpected request ids %+v", req)
+	}
+}
+
+func TestSpanKinds(t *testing.T) {
+	cases := map[ait.SpanKind]string{
+		ait.SpanKindServer:   "",
+		ait.SpanKindConsumer: "",
+		ait.SpanKindInternal: "InProc",
+		ait.SpanKindClient:   "Other",
+		ait.SpanKindProducer: "Queue Message",
+	}
+	for kind, dependencyType := range cases {
+		core, rec := appinsightstest.NewCore("span-test")
+		_, span := core.StartSpan(context.Background(), "operation", kind)
+		span.SetAttribute("url", "/orders?page=2")
+		span.SetAttribute("data", "SELECT * FROM orders")
+		span.SetStatus(false, "500")
+		span.End()
+
+		rec.RequireCount(t, 1)
+		if dependencyType == "" {
+			req := rec.RequireRequest(t, "operation")
+			if req.Url != "/orders?page=2" ||
+				req.ResponseCode != "500" ||
+				req.Success {
+				t.Errorf("kind %d: unexpected request %+v", kind, req)
+			}
+			continue
+		}
+		dep := rec.RequireDependency(t, "operation")
+		if dep.Type != dependencyType ||
+			dep.Data != "SELECT * FROM orders" ||
+			dep.ResultCode != "500" ||
+			dep.Success {
+			t.Errorf("kind %d: unexpected dependency %+v", kind, dep)
+		}
+	}
+
+	core, rec := appinsightstest.NewCore("span-test")
+	_, span := core.StartSpan(context.Background(), "GET /rates", ait.SpanKindClient)
+	span.SetDependency("HTTP", "rates.internal")
+	span.End()
+	dep := rec.RequireDependency(t, "GET /rates")
+	if dep.Type != "HTTP" || dep.Target != "rates.internal" || !dep.Success {
+		t.Errorf("unexpected dependency %+v", dep)
+	}
+}
+
+func TestSpanEndOnce(t *testing.T) {
+	core, rec := appinsightstest.NewCore("span-test")
+	_, span := core.StartSpan(context.Background(), "operation", ait.SpanKindServer)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			span.End()
+		}()
+	}
+	wg.Wait()
+	span.SetStatus(false, "500")
+	span.End()
+
+	rec.RequireCount(t, 1)
+	if req := rec.RequireRequest(t, "operation"); !req.Success {
+		t.Errorf("the request changed after End %+v", req)
+	}
+}
+
+func TestSpanRecordError(t *testing.T) {
+	core, rec := appinsightstest.NewCore("span-test")
+	_, span := core.StartSpan(context.Background(), "LoadOrders", ait.SpanKindInternal)
+	span.RecordError(nil)
+	span.RecordError(errors.New("connection refused"))
+	span.End()
+
+	rec.RequireCount(t, 2)
+	info := span.TraceInfo()
+	ex := rec.RequireException(t)
+	if ex.OperationId != info.TraceId || ex.ParentId != info.SpanId {
+		t.Errorf("exception %+v not linked to the span %+v", ex.TelemetryBase, info)
+	}
+	if ex.Properties["spanName"] != "LoadOrders" {
+		t.Errorf("unexpected exception properties %v", ex.Properties)
+	}
+	if len(ex.Frames) == 0 || ex.Frames[0].Method != "TestSpanRecordError" {
+		t.Errorf("stack doesn't start at the caller of RecordError %+v", ex.Frames)
+	}
+
+	dep := rec.RequireDependency(t, "LoadOrders")
+	if dep.Success || dep.Properties["error"] != "connection refused" {
+		t.Errorf("span not marked as failed %+v", dep)
+	}
+}
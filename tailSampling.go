@@ -0,0 +1,448 @@
+package appinsightstrace
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	// Default maximum time the telemetry of an operation is held
+	DefaultTailSamplingTimeout = 30 * time.Second
+	// Default duration above which a request or dependency makes the trace
+	// always kept
+	DefaultTailSamplingLatencyThreshold = time.Second
+	// Default percentage of the other (successful and fast) traces kept
+	DefaultTailSamplingPercentage = 10
+	// Default maximum number of operations held at once
+	DefaultTailSamplingMaxOperations = 10000
+	// Default maximum number of items held per operation
+	DefaultTailSamplingMaxItems = 1000
+)
+
+// Options for the tail sampling exporter
+//
+// Timeout: the maximum time the telemetry of an operation is held waiting
+//
+//	for its root request, defaults to DefaultTailSamplingTimeout
+//
+// LatencyThreshold: requests and dependencies lasting at least this long make
+//
+//	the trace always kept, defaults to DefaultTailSamplingLatencyThreshold
+//
+// Percentage: the percentage [0, 100] of the other traces kept, defaults to
+//
+//	DefaultTailSamplingPercentage, a negative value keeps none of them
+//
+// MaxOperations: the maximum number of operations held at once, the oldest
+//
+//	operation is decided early when exceeded, also caps the decisions
+//	remembered for the items arriving late (the oldest is forgotten first),
+//	defaults to DefaultTailSamplingMaxOperations
+//
+// MaxItems: the maximum number of items held per operation, further items
+//
+//	are dropped, defaults to DefaultTailSamplingMaxItems
+//
+// Clock: the clock used for the timeouts, defaults to the system clock
+type TailSamplingOptions struct {
+	Timeout          time.Duration `yaml:"timeout"`
+	LatencyThreshold time.Duration `yaml:"latencyThreshold"`
+	Percentage       float64       `yaml:"percentage"`
+	MaxOperations    int           `yaml:"maxOperations"`
+	MaxItems         int           `yaml:"maxItems"`
+	Clock            clock.Clock   `yaml:"-"`
+}
+
+// Counters of the tail sampling exporter
+//
+// KeptOperations: operations kept because they failed, contained an
+//
+//	exception or were slow
+//
+// SampledOperations: other operations kept by the sampling percentage
+// DroppedOperations: other operations dropped by the sampling percentage
+// EvictedOperations: operations decided before completing since
+//
+//	MaxOperations was exceeded
+//
+// TimedOutOperations: operations decided before completing since Timeout
+//
+//	elapsed
+//
+// DroppedItems: items dropped with their operation or because MaxItems was
+//
+//	exceeded
+type TailSamplingStats struct {
+	KeptOperations     int64
+	SampledOperations  int64
+	DroppedOperations  int64
+	EvictedOperations  int64
+	TimedOutOperations int64
+	DroppedItems       int64
+}
+
+// ITelemetryExporter holding all of the telemetry of an operation until its
+// root request completes (a request whose parent is not held, usually the
+// incoming request of the service) or the timeout elapses, the whole trace is
+// then kept if any request or dependency failed or was slow or if it
+// contains an exception, the other traces are sampled by the SamplingScore of
+// the operation id. Items without an operation id and metrics are exported
+// right away, items of an already decided operation follow the decision,
+// except for failed or slow items and exceptions of a dropped operation (ex:
+// the incoming request completing after a nested server or consumer span was
+// taken for the root) which switch the decision to keep the trace. Items
+// exported after Close are passed to the next exporter right away
+type TailSamplingExporter struct {
+	next             ITelemetryExporter
+	timeout          time.Duration
+	latencyThreshold time.Duration
+	percentage       float64
+	maxOperations    int
+	maxItems         int
+	clock            clock.Clock
+
+	mtx        sync.Mutex
+	operations map[string]*tailOperation
+	// held operations, oldest first
+	order   *list.List
+	decided map[string]*tailDecision
+	// decisions, oldest first
+	decidedOrder *list.List
+	stats        TailSamplingStats
+	closed       bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type tailOperation struct {
+	id       string
+	items    []ITelemetry
+	ids      map[string]struct{}
+	started  time.Time
+	element  *list.Element
+	keep     bool
+	overflow int64
+}
+
+type tailDecision struct {
+	id         string
+	element    *list.Element
+	keep       bool
+	percentage float64
+	expires    time.Time
+	// items of a dropped operation, held until the decision expires in case
+	// it is switched to keep
+	dropped []ITelemetry
+}
+
+var _ ITelemetryExporter = (*TailSamplingExporter)(nil)
+
+// Constructs a TailSamplingExporter in front of the next exporter, Close
+// should be called so the held operations are decided and exported
+func NewTailSamplingExporter(
+	next ITelemetryExporter,
+	optn *TailSamplingOptions,
+) *TailSamplingExporter {
+	if optn == nil {
+		optn = &TailSamplingOptions{}
+	}
+	exp := &TailSamplingExporter{
+		next:             next,
+		timeout:          optn.Timeout,
+		latencyThreshold: optn.LatencyThreshold,
+		percentage:       optn.Percentage,
+		maxOperations:    optn.MaxOperations,
+		maxItems:         optn.MaxItems,
+		clock:            optn.Clock,
+		operations:       map[string]*tailOperation{},
+		order:            list.New(),
+		decided:          map[string]*tailDecision{},
+		decidedOrder:     list.New(),
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	if exp.timeout <= 0 {
+		exp.timeout = DefaultTailSamplingTimeout
+	}
+	if exp.latencyThreshold <= 0 {
+		exp.latencyThreshold = DefaultTailSamplingLatencyThreshold
+	}
+	if exp.percentage == 0 {
+		exp.percentage = DefaultTailSamplingPercentage
+	}
+	exp.percentage = clampPercentage(exp.percentage)
+	if exp.maxOperations <= 0 {
+		exp.maxOperations = DefaultTailSamplingMaxOperations
+	}
+	if exp.maxItems <= 0 {
+		exp.maxItems = DefaultTailSamplingMaxItems
+	}
+	if exp.clock == nil {
+		exp.clock = clock.NewClock()
+	}
+	go exp.run()
+	return exp
+}
+
+func (exp *TailSamplingExporter) Export(item ITelemetry) {
+	operationId := item.Base().OperationId
+	if _, isMetric := item.(*MetricTelemetry); isMetric || operationId == "" {
+		exp.next.Export(item)
+		return
+	}
+
+	now := exp.clock.Now()
+	exp.mtx.Lock()
+	if exp.closed {
+		exp.mtx.Unlock()
+		exp.next.Export(item)
+		return
+	}
+	if decision, ok := exp.decided[operationId]; ok {
+		flushed := exp.follow(decision, item)
+		exp.mtx.Unlock()
+		exp.export([][]ITelemetry{flushed})
+		return
+	}
+
+	op, ok := exp.operations[operationId]
+	if !ok {
+		op = &tailOperation{
+			id:      operationId,
+			ids:     map[string]struct{}{},
+			started: now,
+		}
+		op.element = exp.order.PushBack(op)
+		exp.operations[operationId] = op
+	}
+	exp.hold(op, item)
+
+	flushed := [][]ITelemetry{}
+	if req, isRequest := item.(*RequestTelemetry); isRequest {
+		if _, hasParent := op.ids[req.ParentId]; !hasParent {
+			flushed = append(flushed, exp.decide(op, now))
+		}
+	}
+	for len(exp.operations) > exp.maxOperations {
+		oldest := exp.order.Front().Value.(*tailOperation)
+		exp.stats.EvictedOperations++
+		flushed = append(flushed, exp.decide(oldest, now))
+	}
+	exp.mtx.Unlock()
+
+	exp.export(flushed)
+}
+
+func (exp *TailSamplingExporter) Flush() {
+	exp.next.Flush()
+}
+
+// Decides and exports all of the held operations and closes the next
+// exporter, the items exported afterwards are no longer held
+func (exp *TailSamplingExporter) Close() {
+	exp.once.Do(func() {
+		close(exp.stop)
+		<-exp.done
+		exp.mtx.Lock()
+		exp.closed = true
+		exp.mtx.Unlock()
+		exp.export(exp.decideAll(func(*tailOperation) bool { return true }))
+		exp.next.Close()
+	})
+}
+
+// Gets a snapshot of the counters
+func (exp *TailSamplingExporter) Stats() TailSamplingStats {
+	exp.mtx.Lock()
+	defer exp.mtx.Unlock()
+	return exp.stats
+}
+
+// Decides the operations that timed out and forgets expired decisions
+func (exp *TailSamplingExporter) run() {
+	defer close(exp.done)
+	ticker := exp.clock.NewTicker(exp.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			now := exp.clock.Now()
+			exp.export(exp.decideAll(func(op *tailOperation) bool {
+				if now.Sub(op.started) < exp.timeout {
+					return false
+				}
+				exp.stats.TimedOutOperations++
+				return true
+			}))
+			exp.mtx.Lock()
+			for elem := exp.decidedOrder.Front(); elem != nil; {
+				decision := elem.Value.(*tailDecision)
+				elem = elem.Next()
+				if now.Before(decision.expires) {
+					break
+				}
+				exp.forget(decision)
+			}
+			exp.mtx.Unlock()
+		case <-exp.stop:
+			return
+		}
+	}
+}
+
+// Whether the item makes its trace always kept (failed or slow requests and
+// dependencies and exceptions)
+func (exp *TailSamplingExporter) isKept(item ITelemetry) bool {
+	switch tele := item.(type) {
+	case *RequestTelemetry:
+		return !tele.Success || tele.Duration >= exp.latencyThreshold
+	case *DependencyTelemetry:
+		return !tele.Success || tele.Duration >= exp.latencyThreshold
+	case *ExceptionTelemetry:
+		return true
+	default:
+		return false
+	}
+}
+
+// Adds the item to the operation, must be called with the lock held
+func (exp *TailSamplingExporter) hold(op *tailOperation, item ITelemetry) {
+	switch tele := item.(type) {
+	case *RequestTelemetry:
+		if tele.Id != "" {
+			op.ids[tele.Id] = struct{}{}
+		}
+	case *DependencyTelemetry:
+		if tele.Id != "" {
+			op.ids[tele.Id] = struct{}{}
+		}
+	}
+	op.keep = op.keep || exp.isKept(item)
+	if len(op.items) >= exp.maxItems {
+		op.overflow++
+		return
+	}
+	op.items = append(op.items, item)
+}
+
+// Removes the operation and returns the items to be exported, must be called
+// with the lock held
+func (exp *TailSamplingExporter) decide(
+	op *tailOperation,
+	now time.Time,
+) []ITelemetry {
+	delete(exp.operations, op.id)
+	exp.order.Remove(op.element)
+	exp.stats.DroppedItems += op.overflow
+
+	decision := &tailDecision{
+		id:         op.id,
+		keep:       true,
+		percentage: 100,
+		expires:    now.Add(exp.timeout),
+	}
+	switch {
+	case op.keep:
+		exp.stats.KeptOperations++
+	case SamplingScore(op.id) < exp.percentage:
+		exp.stats.SampledOperations++
+		decision.percentage = exp.percentage
+	default:
+		exp.stats.DroppedOperations++
+		exp.stats.DroppedItems += int64(len(op.items))
+		decision.keep = false
+	}
+
+	if len(exp.decided) >= exp.maxOperations {
+		exp.forget(exp.decidedOrder.Front().Value.(*tailDecision))
+	}
+	decision.element = exp.decidedOrder.PushBack(decision)
+	exp.decided[op.id] = decision
+
+	if !decision.keep {
+		decision.dropped = op.items
+		return nil
+	}
+	for _, item := range op.items {
+		withSampleRate(item, decision.percentage)
+	}
+	return op.items
+}
+
+// Removes the decision, must be called with the lock held
+func (exp *TailSamplingExporter) forget(decision *tailDecision) {
+	delete(exp.decided, decision.id)
+	exp.decidedOrder.Remove(decision.element)
+}
+
+// Applies the decision to an item of an already decided operation and returns
+// the items to be exported, a dropped operation is switched to kept if the
+// item is failed or slow, must be called with the lock held
+func (exp *TailSamplingExporter) follow(
+	decision *tailDecision,
+	item ITelemetry,
+) []ITelemetry {
+	if decision.keep {
+		return []ITelemetry{withSampleRate(item, decision.percentage)}
+	}
+	if !exp.isKept(item) {
+		exp.stats.DroppedItems++
+		if len(decision.dropped) < exp.maxItems {
+			decision.dropped = append(decision.dropped, item)
+		}
+		return nil
+	}
+	exp.stats.DroppedOperations--
+	exp.stats.KeptOperations++
+	exp.stats.DroppedItems -= int64(len(decision.dropped))
+	flushed := append(decision.dropped, item)
+	decision.keep = true
+	decision.percentage = 100
+	decision.dropped = nil
+	return flushed
+}
+
+func (exp *TailSamplingExporter) decideAll(
+	filter func(*tailOperation) bool,
+) [][]ITelemetry {
+	now := exp.clock.Now()
+	exp.mtx.Lock()
+	defer exp.mtx.Unlock()
+	flushed := [][]ITelemetry{}
+	for elem := exp.order.Front(); elem != nil; {
+		op := elem.Value.(*tailOperation)
+		elem = elem.Next()
+		if filter(op) {
+			flushed = append(flushed, exp.decide(op, now))
+		}
+	}
+	return flushed
+}
+
+func (exp *TailSamplingExporter) export(flushed [][]ITelemetry) {
+	for _, items := range flushed {
+		for _, item := range items {
+			exp.next.Export(item)
+		}
+	}
+}
+
+// Combines the tail sampling percentage with the sample rate the item may
+// already carry from head sampling
+func withSampleRate(item ITelemetry, percentage float64) ITelemetry {
+	if percentage >= 100 {
+		return item
+	}
+	base := item.Base()
+	if base.SampleRate > 0 && base.SampleRate < 100 {
+		base.SampleRate = base.SampleRate * percentage / 100
+	} else {
+		base.SampleRate = percentage
+	}
+	return item
+}
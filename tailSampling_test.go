@@ -0,0 +1,159 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+)
+
+// Constructs a core exporting through a tail sampling exporter that drops
+// all of the successful and fast traces
+func newTailSampledCore(
+	t *testing.T,
+) (*ait.AppInsightsCore, *ait.TailSamplingExporter, *appinsightstest.Recorder) {
+	rec := appinsightstest.NewRecorder()
+	exp := ait.NewTailSamplingExporter(rec, &ait.TailSamplingOptions{
+		Percentage: -1,
+		Clock:      fakeclock.NewFakeClock(time.Now()),
+	})
+	t.Cleanup(exp.Close)
+	return ait.NewAppInsightsCoreWithExporter("tail-test", exp, nil), exp, rec
+}
+
+// Serves a request whose handler ends a nested span of the kind before
+// responding with the status code, the nested span completes (and is
+// exported) before the incoming request
+func serveNestedSpan(
+	core *ait.AppInsightsCore,
+	kind ait.SpanKind,
+	statusCode int,
+) {
+	handler := core.HttpMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, span := core.StartSpan(r.Context(), "ProcessMessage", kind)
+			span.End()
+			w.WriteHeader(statusCode)
+		},
+	))
+	handler.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/orders", nil),
+	)
+}
+
+func TestTailSamplingNestedSpans(t *testing.T) {
+	kinds := map[string]ait.SpanKind{
+		"server":   ait.SpanKindServer,
+		"consumer": ait.SpanKindConsumer,
+	}
+	for name, kind := range kinds {
+		t.Run(name+" failed", func(t *testing.T) {
+			core, exp, rec := newTailSampledCore(t)
+			serveNestedSpan(core, kind, http.StatusInternalServerError)
+
+			rec.RequireCount(t, 2)
+			req := rec.RequireRequest(t, "GET /orders")
+			rec.RequireTraceTree(t, req.OperationId, appinsightstest.TreeSpec{
+				Name:     "GET /orders",
+				Children: []appinsightstest.TreeSpec{{Name: "ProcessMessage"}},
+			})
+			stats := exp.Stats()
+			if stats.KeptOperations != 1 ||
+				stats.DroppedOperations != 0 ||
+				stats.DroppedItems != 0 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+
+		t.Run(name+" successful", func(t *testing.T) {
+			core, exp, rec := newTailSampledCore(t)
+			serveNestedSpan(core, kind, http.StatusOK)
+
+			rec.RequireCount(t, 0)
+			stats := exp.Stats()
+			if stats.KeptOperations != 0 ||
+				stats.DroppedOperations != 1 ||
+				stats.DroppedItems != 2 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestTailSamplingLateException(t *testing.T) {
+	core, exp, rec := newTailSampledCore(t)
+	tid, rid := ait.NewTraceId(), ait.NewSpanId()
+	now := time.Now()
+
+	core.TraceRequestWithIds(
+		tid, "", rid, "GET", "/orders", "", 200, 0, "", "", now, now, nil,
+	)
+	rec.RequireCount(t, 0)
+
+	// items of the dropped operation are held until the decision expires
+	core.TraceLogInfo(context.Background(), ait.LogInfo{
+		TraceId:  tid,
+		ParentId: rid,
+		Message:  "processed",
+	})
+	rec.RequireCount(t, 0)
+
+	core.TraceExceptionWithIds(tid, rid, errors.New("late failure"), 0, nil)
+	rec.RequireCount(t, 3)
+	rec.RequireException(t)
+	rec.RequireTrace(t, "processed")
+	if stats := exp.Stats(); stats.KeptOperations != 1 || stats.DroppedOperations != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestTailSamplingForgetsOldestDecision(t *testing.T) {
+	rec := appinsightstest.NewRecorder()
+	exp := ait.NewTailSamplingExporter(rec, &ait.TailSamplingOptions{
+		Percentage:    -1,
+		MaxOperations: 2,
+		Clock:         fakeclock.NewFakeClock(time.Now()),
+	})
+	defer exp.Close()
+	core := ait.NewAppInsightsCoreWithExporter("tail-test", exp, nil)
+	now := time.Now()
+
+	previous := ""
+	for i := 0; i < 20; i++ {
+		tid := ait.NewTraceId()
+		core.TraceRequestWithIds(
+			tid, "", ait.NewSpanId(), "GET", "/orders", "", 200, 0, "", "", now, now, nil,
+		)
+		// the decision of the previous operation is remembered until two
+		// more recent decisions exist
+		if previous != "" {
+			rec.Reset()
+			core.TraceExceptionWithIds(previous, "", errors.New("late failure"), 0, nil)
+			if len(rec.Operation(previous)) != 2 {
+				t.Fatalf("decision of %s forgotten before the oldest one", previous)
+			}
+		}
+		previous = tid
+	}
+}
+
+func TestTailSamplingExportAfterClose(t *testing.T) {
+	core, exp, rec := newTailSampledCore(t)
+	exp.Close()
+	now := time.Now()
+
+	core.TraceRequestWithIds(
+		ait.NewTraceId(), "", ait.NewSpanId(), "GET", "/orders", "", 200, 0, "", "", now, now, nil,
+	)
+	rec.RequireCount(t, 1)
+	if stats := exp.Stats(); stats != (ait.TailSamplingStats{}) {
+		t.Errorf("items after Close sampled %+v", stats)
+	}
+}
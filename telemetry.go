@@ -0,0 +1,188 @@
+package appinsightstrace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Common interface implemented by every telemetry item produced by
+// AppInsightsCore and consumed by an ITelemetryExporter
+type ITelemetry interface {
+	// Gets the fields common to all telemetry items
+	Base() *TelemetryBase
+}
+
+// Fields common to all telemetry items
+//
+// Timestamp: when the item was measured (start of the operation for requests
+//
+//	and dependencies)
+//
+// OperationId: the trace id of the operation the item belongs to
+// ParentId: the id of the direct parent of the item within the operation
+// OperationName: the name of the operation the item belongs to
+// CloudRole: the name of the service producing the item
+// Tags: additional context tags (https://github.com/microsoft/ApplicationInsights-Go/blob/master/appinsights/contracts/contexttagkeys.go)
+// Properties: custom values
+// Measurements: custom numeric values
+// SampleRate: the sampling percentage (0, 100] the item was kept with, set
+//
+//	when the item is tracked, zero is treated as not sampled (100)
+type TelemetryBase struct {
+	Timestamp     time.Time
+	OperationId   string
+	ParentId      string
+	OperationName string
+	CloudRole     string
+	Tags          map[string]string
+	Properties    map[string]string
+	Measurements  map[string]float64
+	SampleRate    float64
+}
+
+// Gets the fields common to all telemetry items
+func (base *TelemetryBase) Base() *TelemetryBase {
+	return base
+}
+
+// An incoming request handled by the service
+type RequestTelemetry struct {
+	TelemetryBase
+	Id           string
+	Name         string
+	Url          string
+	ResponseCode string
+	Success      bool
+	Duration     time.Duration
+}
+
+// A page view served by the service
+type PageViewTelemetry struct {
+	TelemetryBase
+	Name     string
+	Url      string
+	Duration time.Duration
+}
+
+// An outgoing call made by the service (http request, database call etc.)
+type DependencyTelemetry struct {
+	TelemetryBase
+	Id         string
+	Name       string
+	Type       string
+	Target     string
+	ResultCode string
+	Data       string
+	Success    bool
+	Duration   time.Duration
+}
+
+// A trace log message
+type TraceTelemetry struct {
+	TelemetryBase
+	Message       string
+	SeverityLevel SeverityLevel
+}
+
+// An unexpected error or panic, Error may be an error, string, fmt.Stringer
+// or fmt.GoStringer, Message overrides the message of Error when not empty
+// (ex: when redacted), InnerExceptions are the errors wrapped by Error and
+// ProblemId overrides the grouping of the exception in the Failures blade
+type ExceptionTelemetry struct {
+	TelemetryBase
+	Error           interface{}
+	Message         string
+	Frames          []*contracts.StackFrame
+	SeverityLevel   SeverityLevel
+	InnerExceptions []*ExceptionDetails
+	ProblemId       string
+}
+
+// A metric value, Count is zero for a single measured value and the number of
+// aggregated values otherwise, in which case Value is the sum of the values
+type MetricTelemetry struct {
+	TelemetryBase
+	Name   string
+	Value  float64
+	Count  int
+	Min    float64
+	Max    float64
+	StdDev float64
+}
+
+// A custom (business) event
+type EventTelemetry struct {
+	TelemetryBase
+	Name string
+}
+
+// Builds the common fields for a new telemetry item
+func (ins *AppInsightsCore) newTelemetryBase(
+	timestamp time.Time,
+	operationId string,
+	parentId string,
+	fields map[string]string,
+) TelemetryBase {
+	return TelemetryBase{
+		Timestamp:    timestamp,
+		OperationId:  operationId,
+		ParentId:     parentId,
+		CloudRole:    ins.ServName,
+		Tags:         make(map[string]string),
+		Properties:   copyFields(fields),
+		Measurements: make(map[string]float64),
+	}
+}
+
+// Copies the fields into a new map, a nil map is treated as empty, so the
+// telemetry never shares (and the pipeline never mutates) the map of the
+// caller
+func copyFields(fields map[string]string) map[string]string {
+	props := make(map[string]string, len(fields))
+	for key, val := range fields {
+		props[key] = val
+	}
+	return props
+}
+
+// Runs the telemetry item through the pipeline and the sampler and hands it
+// over to the exporter if kept
+func (ins *AppInsightsCore) track(item ITelemetry) {
+	if !ins.process(item) {
+		return
+	}
+	if _, isMetric := item.(*MetricTelemetry); ins.sampler != nil && !isMetric {
+		keep, percentage := ins.sampler.Sample(item)
+		if !keep {
+			return
+		}
+		item.Base().SampleRate = percentage
+	}
+	ins.exporter.Export(item)
+}
+
+// Gets the name of the telemetry type of the item (Request, PageView,
+// Dependency, Trace, Exception, Metric or Event), the Go type name is
+// returned for other implementations of ITelemetry
+func telemetryType(item ITelemetry) string {
+	switch item.(type) {
+	case *RequestTelemetry:
+		return "Request"
+	case *PageViewTelemetry:
+		return "PageView"
+	case *DependencyTelemetry:
+		return "Dependency"
+	case *TraceTelemetry:
+		return "Trace"
+	case *ExceptionTelemetry:
+		return "Exception"
+	case *MetricTelemetry:
+		return "Metric"
+	case *EventTelemetry:
+		return "Event"
+	default:
+		return fmt.Sprintf("%T", item)
+	}
+}
@@ -0,0 +1,498 @@
+package appinsightstrace
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Parameters of TraceRequestInfo, only the fields that are set are used
+//
+// TraceId: the trace id of the operation, extracted from the context if empty
+// ParentId: the id of the direct dependent of the request, extracted from
+//
+//	the context if empty
+//
+// RequestId: the unique id of the request, extracted from the context if
+//
+//	empty
+//
+// Name: the name of the operation, defaults to Method and Path
+//
+//	(ex: GET /api/v1/weather)
+//
+// Url: the url of the request, defaults to Path and Query
+// Method: the http request method
+// Path: the path of the request (ex: /api/v1/weather)
+// Query: the query of the request (ex: ?city=london)
+// StatusCode: the response http status code
+// ResponseCode: the response code, defaults to StatusCode when set
+// Success: whether the request succeeded, defaults to StatusCode being 1xx
+//
+//	or 2xx
+//
+// StartTimestamp: when the request was received by this service
+// EndTimestamp: when the request has completed processing by this service
+// UserId: the id of the authenticated user
+// SessionId: the id of the user session
+// Tags: additional context tags
+// Measurements: custom numeric values
+// Fields: custom values
+type RequestInfo struct {
+	TraceId        string
+	ParentId       string
+	RequestId      string
+	Name           string
+	Url            string
+	Method         string
+	Path           string
+	Query          string
+	StatusCode     int
+	ResponseCode   string
+	Success        *bool
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	UserId         string
+	SessionId      string
+	Tags           map[string]string
+	Measurements   map[string]float64
+	Fields         map[string]string
+}
+
+// Parameters of TracePageViewInfo, only the fields that are set are used
+//
+// TraceId: the trace id of the operation, extracted from the context if empty
+// ParentId: the id of the direct dependent of the page view, extracted from
+//
+//	the context if empty
+//
+// Name: the name of the page, defaults to GET and Path (ex: GET /Home)
+// Url: the url of the page, defaults to Path
+// Path: the path of the page (ex: /Home)
+// StartTimestamp: when the request for the page was received
+// EndTimestamp: when the page was served
+// UserId: the id of the authenticated user
+// SessionId: the id of the user session
+// Tags: additional context tags
+// Measurements: custom numeric values
+// Fields: custom values
+type PageViewInfo struct {
+	TraceId        string
+	ParentId       string
+	Name           string
+	Url            string
+	Path           string
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	UserId         string
+	SessionId      string
+	Tags           map[string]string
+	Measurements   map[string]float64
+	Fields         map[string]string
+}
+
+// Parameters of TraceDependencyInfo, only the fields that are set are used
+//
+// TraceId: the trace id of the operation, extracted from the context if empty
+// ParentId: the id of the request (or span) making the call, extracted from
+//
+//	the context if empty
+//
+// SpanId: the id of the dependency, recommended when the dependency also
+//
+//	follows w3c tracing (outgoing http calls)
+//
+// Type: the type of the dependency (ex: HTTP, postgres, rabbitmq)
+// Target: the unique name of the dependency (ex: database address)
+// Name: the name of the action performed (ex: GET /api/v1/weather)
+// ResultCode: the result of the call (ex: http status code)
+// Data: the full command of the call (ex: the url or the SQL statement)
+// Success: whether the call succeeded
+// StartTimestamp: when the dependency was invoked
+// EndTimestamp: when the dependency completed
+// UserId: the id of the authenticated user
+// SessionId: the id of the user session
+// Tags: additional context tags
+// Measurements: custom numeric values
+// Fields: custom values
+type DependencyInfo struct {
+	TraceId        string
+	ParentId       string
+	SpanId         string
+	Type           string
+	Target         string
+	Name           string
+	ResultCode     string
+	Data           string
+	Success        bool
+	StartTimestamp time.Time
+	EndTimestamp   time.Time
+	UserId         string
+	SessionId      string
+	Tags           map[string]string
+	Measurements   map[string]float64
+	Fields         map[string]string
+}
+
+// Parameters of TraceLogInfo, only the fields that are set are used
+//
+// TraceId: the trace id of the operation, extracted from the context if empty
+// ParentId: the id of the request (or span) logging, extracted from the
+//
+//	context if empty
+//
+// Message: the message that is to be traced
+// SeverityLevel: the severity level, defaults to Verbose
+// Timestamp: when the message was logged, defaults to now
+// UserId: the id of the authenticated user
+// SessionId: the id of the user session
+// Tags: additional context tags
+// Measurements: custom numeric values, recorded as custom values since trace
+//
+//	logs do not support measurements
+//
+// Fields: custom values
+type LogInfo struct {
+	TraceId       string
+	ParentId      string
+	Message       string
+	SeverityLevel SeverityLevel
+	Timestamp     time.Time
+	UserId        string
+	SessionId     string
+	Tags          map[string]string
+	Measurements  map[string]float64
+	Fields        map[string]string
+}
+
+// Parameters of TraceExceptionInfo, only the fields that are set are used
+//
+// TraceId: the trace id of the operation, extracted from the context if empty
+// ParentId: the id of the request (or span) failing, extracted from the
+//
+//	context if empty
+//
+// Error: the unexpected error object (error, string, fmt.Stringer or
+//
+//	fmt.GoStringer), the errors it wraps are sent as inner exceptions
+//
+// Skip: the number of levels to skip on the call stack, unused if the error
+//
+//	recorded its stack (github.com/pkg/errors)
+//
+// SeverityLevel: the severity level, defaults to Error
+// ProblemId: groups the exception with the others of the same problem id in
+//
+//	the Failures blade instead of grouping by type and call stack
+//
+// Timestamp: when the exception occurred, defaults to now
+// UserId: the id of the authenticated user
+// SessionId: the id of the user session
+// Tags: additional context tags
+// Measurements: custom numeric values
+// Fields: custom values
+type ExceptionInfo struct {
+	TraceId       string
+	ParentId      string
+	Error         interface{}
+	Skip          int
+	SeverityLevel *SeverityLevel
+	ProblemId     string
+	Timestamp     time.Time
+	UserId        string
+	SessionId     string
+	Tags          map[string]string
+	Measurements  map[string]float64
+	Fields        map[string]string
+}
+
+// Transmits a new Request telemetry described by info, the ids that are not
+// set in info are extracted from the context with ITraceExtractor
+func (ins *AppInsightsCore) TraceRequestInfo(
+	ctx context.Context,
+	info RequestInfo,
+) {
+	_, tid, pid, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	info.TraceId = valueOr(info.TraceId, tid)
+	info.ParentId = valueOr(info.ParentId, pid)
+	info.RequestId = valueOr(info.RequestId, rid)
+	ins.traceRequestInfo(info)
+}
+
+// Builds and transmits the Request telemetry described by info, only the
+// ids of info are used
+func (ins *AppInsightsCore) traceRequestInfo(info RequestInfo) {
+	ins.track(ins.newRequestTelemetry(info))
+}
+
+// Builds the Request telemetry described by info
+func (ins *AppInsightsCore) newRequestTelemetry(
+	info RequestInfo,
+) *RequestTelemetry {
+	name := valueOr(info.Name, fmt.Sprintf("%s %s", info.Method, info.Path))
+	success := info.StatusCode > 99 && info.StatusCode < 300
+	if info.Success != nil {
+		success = *info.Success
+	}
+	if info.ResponseCode == "" && info.StatusCode != 0 {
+		info.ResponseCode = strconv.Itoa(info.StatusCode)
+	}
+
+	tele := &RequestTelemetry{
+		Name:         name,
+		Url:          valueOr(info.Url, info.Path+info.Query),
+		Id:           info.RequestId,
+		Duration:     info.EndTimestamp.Sub(info.StartTimestamp),
+		ResponseCode: info.ResponseCode,
+		Success:      success,
+		TelemetryBase: ins.newTelemetryBase(
+			info.StartTimestamp,
+			info.TraceId,
+			info.ParentId,
+			info.Fields,
+		),
+	}
+	tele.OperationName = name
+	applyTelemetryInfo(
+		&tele.TelemetryBase,
+		info.UserId,
+		info.SessionId,
+		info.Tags,
+		info.Measurements,
+	)
+	return tele
+}
+
+// Transmits a new PageView telemetry described by info, the ids that are not
+// set in info are extracted from the context with ITraceExtractor
+func (ins *AppInsightsCore) TracePageViewInfo(
+	ctx context.Context,
+	info PageViewInfo,
+) {
+	_, tid, pid, _, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	info.TraceId = valueOr(info.TraceId, tid)
+	info.ParentId = valueOr(info.ParentId, pid)
+	ins.tracePageViewInfo(info)
+}
+
+// Builds and transmits the PageView telemetry described by info, only the
+// ids of info are used
+func (ins *AppInsightsCore) tracePageViewInfo(info PageViewInfo) {
+	ins.track(ins.newPageViewTelemetry(info))
+}
+
+// Builds the PageView telemetry described by info
+func (ins *AppInsightsCore) newPageViewTelemetry(
+	info PageViewInfo,
+) *PageViewTelemetry {
+	name := valueOr(info.Name, "GET "+info.Path)
+
+	tele := &PageViewTelemetry{
+		Name:     name,
+		Url:      valueOr(info.Url, info.Path),
+		Duration: info.EndTimestamp.Sub(info.StartTimestamp),
+		TelemetryBase: ins.newTelemetryBase(
+			info.StartTimestamp,
+			info.TraceId,
+			info.ParentId,
+			info.Fields,
+		),
+	}
+	tele.OperationName = name
+	applyTelemetryInfo(
+		&tele.TelemetryBase,
+		info.UserId,
+		info.SessionId,
+		info.Tags,
+		info.Measurements,
+	)
+	return tele
+}
+
+// Transmits a new Dependency telemetry described by info, the ids that are not
+// set in info are extracted from the context with ITraceExtractor
+func (ins *AppInsightsCore) TraceDependencyInfo(
+	ctx context.Context,
+	info DependencyInfo,
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	info.TraceId = valueOr(info.TraceId, tid)
+	info.ParentId = valueOr(info.ParentId, rid)
+	ins.traceDependencyInfo(info)
+}
+
+// Builds and transmits the Dependency telemetry described by info, only the
+// ids of info are used
+func (ins *AppInsightsCore) traceDependencyInfo(info DependencyInfo) {
+	tele := &DependencyTelemetry{
+		Id:         info.SpanId,
+		Name:       info.Name,
+		Type:       info.Type,
+		Target:     info.Target,
+		ResultCode: info.ResultCode,
+		Data:       info.Data,
+		Success:    info.Success,
+		Duration:   info.EndTimestamp.Sub(info.StartTimestamp),
+		TelemetryBase: ins.newTelemetryBase(
+			info.StartTimestamp,
+			info.TraceId,
+			info.ParentId,
+			info.Fields,
+		),
+	}
+	tele.OperationName = info.Name
+	applyTelemetryInfo(
+		&tele.TelemetryBase,
+		info.UserId,
+		info.SessionId,
+		info.Tags,
+		info.Measurements,
+	)
+	ins.track(tele)
+}
+
+// Transmits a new trace log telemetry described by info, the ids that are not
+// set in info are extracted from the context with ITraceExtractor
+func (ins *AppInsightsCore) TraceLogInfo(
+	ctx context.Context,
+	info LogInfo,
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	info.TraceId = valueOr(info.TraceId, tid)
+	info.ParentId = valueOr(info.ParentId, rid)
+	ins.traceLogInfo(info)
+}
+
+// Builds and transmits the trace log telemetry described by info, only the
+// ids of info are used
+func (ins *AppInsightsCore) traceLogInfo(info LogInfo) {
+	if info.Timestamp.IsZero() {
+		info.Timestamp = time.Now()
+	}
+
+	props := info.Fields
+	if len(info.Measurements) != 0 {
+		props = make(map[string]string, len(info.Fields)+len(info.Measurements))
+		for key, val := range info.Fields {
+			props[key] = val
+		}
+		for key, val := range info.Measurements {
+			props[key] = strconv.FormatFloat(val, 'g', -1, 64)
+		}
+	}
+	tele := &TraceTelemetry{
+		Message:       info.Message,
+		SeverityLevel: info.SeverityLevel,
+		TelemetryBase: ins.newTelemetryBase(
+			info.Timestamp,
+			info.TraceId,
+			info.ParentId,
+			props,
+		),
+	}
+	applyTelemetryInfo(
+		&tele.TelemetryBase,
+		info.UserId,
+		info.SessionId,
+		info.Tags,
+		nil,
+	)
+	ins.track(tele)
+}
+
+// Transmits a new exception telemetry described by info with the call stack
+// of the caller (or the stack recorded by the error), the errors wrapped by
+// info.Error (errors.Unwrap, errors.Join and github.com/pkg/errors Cause
+// chains) are sent as inner exceptions, the ids that are not set in info are
+// extracted from the context with ITraceExtractor
+func (ins *AppInsightsCore) TraceExceptionInfo(
+	ctx context.Context,
+	info ExceptionInfo,
+) {
+	_, tid, _, rid, _ := ins.traceExtractor.ExtractTraceInfo(ctx)
+	info.TraceId = valueOr(info.TraceId, tid)
+	info.ParentId = valueOr(info.ParentId, rid)
+	ins.traceExceptionInfo(info, nil)
+}
+
+// Builds and transmits the exception telemetry described by info, only the
+// ids of info are used, with the stack if not nil, the stack recorded by the
+// error or the call stack of the caller of the caller otherwise
+func (ins *AppInsightsCore) traceExceptionInfo(
+	info ExceptionInfo,
+	stack []*contracts.StackFrame,
+) {
+	if info.Timestamp.IsZero() {
+		info.Timestamp = time.Now()
+	}
+	severityLevel := Error
+	if info.SeverityLevel != nil {
+		severityLevel = *info.SeverityLevel
+	}
+
+	var frames []*contracts.StackFrame
+	var inner []*ExceptionDetails
+	if err, ok := info.Error.(error); ok && err != nil {
+		frames, inner = errorChain(err)
+	}
+	if stack != nil {
+		frames = stack
+	} else if frames == nil {
+		frames = appinsights.GetCallstack(3 + info.Skip)
+	}
+
+	tele := &ExceptionTelemetry{
+		Error:           info.Error,
+		Frames:          frames,
+		SeverityLevel:   severityLevel,
+		InnerExceptions: inner,
+		ProblemId:       info.ProblemId,
+		TelemetryBase: ins.newTelemetryBase(
+			info.Timestamp,
+			info.TraceId,
+			info.ParentId,
+			info.Fields,
+		),
+	}
+	applyTelemetryInfo(
+		&tele.TelemetryBase,
+		info.UserId,
+		info.SessionId,
+		info.Tags,
+		info.Measurements,
+	)
+	ins.track(tele)
+}
+
+// Sets the optional fields shared by the info parameter types
+func applyTelemetryInfo(
+	base *TelemetryBase,
+	userId string,
+	sessionId string,
+	tags map[string]string,
+	measurements map[string]float64,
+) {
+	for key, val := range tags {
+		base.Tags[key] = val
+	}
+	if userId != "" {
+		base.Tags[contracts.UserId] = userId
+	}
+	if sessionId != "" {
+		base.Tags[contracts.SessionId] = sessionId
+	}
+	for key, val := range measurements {
+		base.Measurements[key] = val
+	}
+}
+
+func valueOr(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,315 @@
+package appinsightstrace_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+)
+
+// Builds a context carrying a new trace with a parent and a span id
+func newTracedContext() (context.Context, ait.TraceInfo) {
+	info := ait.TraceInfo{
+		TraceId:  ait.NewTraceId(),
+		ParentId: ait.NewSpanId(),
+		SpanId:   ait.NewSpanId(),
+	}
+	return ait.ContextWithTraceInfo(context.Background(), info), info
+}
+
+func TestTraceRequestInfo(t *testing.T) {
+	core, rec := appinsightstest.NewCore("info-test")
+	ctx, info := newTracedContext()
+	start := time.Now().Add(-time.Second)
+	end := start.Add(250 * time.Millisecond)
+	failed := false
+
+	core.TraceRequestInfo(ctx, ait.RequestInfo{
+		Method:         "GET",
+		Path:           "/orders",
+		Query:          "?page=2",
+		StatusCode:     404,
+		StartTimestamp: start,
+		EndTimestamp:   end,
+		UserId:         "user",
+		SessionId:      "session",
+	})
+	core.TraceRequestInfo(ctx, ait.RequestInfo{
+		TraceId:    "trace",
+		ParentId:   "parent",
+		RequestId:  "request",
+		Name:       "POST /orders/{id}",
+		Url:        "/orders/42",
+		StatusCode: 200,
+		Success:    &failed,
+	})
+	core.TraceRequestInfo(ctx, ait.RequestInfo{
+		Name:         "ProcessMessage",
+		ResponseCode: "Ok",
+	})
+
+	req := rec.RequireRequest(t, "GET /orders")
+	if req.OperationId != info.TraceId || req.ParentId != info.ParentId || req.Id != info.SpanId {
+		t.Errorf("ids not extracted from the context %+v", req)
+	}
+	if req.Url != "/orders?page=2" ||
+		req.ResponseCode != "404" ||
+		req.Success ||
+		req.Duration != 250*time.Millisecond ||
+		!req.Timestamp.Equal(start) ||
+		req.OperationName != "GET /orders" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if req.Tags[contracts.UserId] != "user" || req.Tags[contracts.SessionId] != "session" {
+		t.Errorf("unexpected tags %v", req.Tags)
+	}
+
+	named := rec.RequireRequest(t, "POST /orders/{id}")
+	if named.OperationId != "trace" || named.ParentId != "parent" || named.Id != "request" {
+		t.Errorf("ids of info not used %+v", named)
+	}
+	if named.Url != "/orders/42" || named.ResponseCode != "200" || named.Success {
+		t.Errorf("explicit values not used %+v", named)
+	}
+
+	// without a status code nothing is derived
+	event := rec.RequireRequest(t, "ProcessMessage")
+	if event.ResponseCode != "Ok" || event.Success {
+		t.Errorf("unexpected request %+v", event)
+	}
+}
+
+func TestTracePageViewInfo(t *testing.T) {
+	core, rec := appinsightstest.NewCore("info-test")
+	ctx, info := newTracedContext()
+	start := time.Now()
+
+	core.TracePageViewInfo(ctx, ait.PageViewInfo{
+		Path:           "/home",
+		StartTimestamp: start,
+		EndTimestamp:   start.Add(time.Second),
+	})
+	rec.RequireCount(t, 1)
+	view := rec.PageViews()[0]
+	if view.Name != "GET /home" || view.Url != "/home" || view.Duration != time.Second {
+		t.Errorf("unexpected page view %+v", view)
+	}
+	if view.OperationId != info.TraceId || view.ParentId != info.ParentId {
+		t.Errorf("ids not extracted from the context %+v", view.TelemetryBase)
+	}
+}
+
+func TestTraceDependencyInfo(t *testing.T) {
+	core, rec := appinsightstest.NewCore("info-test")
+	ctx, info := newTracedContext()
+
+	core.TraceDependencyInfo(ctx, ait.DependencyInfo{
+		SpanId:     "span",
+		Type:       "SQL",
+		Target:     "orders-db",
+		Name:       "SELECT orders",
+		ResultCode: "1205",
+		Data:       "SELECT * FROM orders",
+		Success:    false,
+	})
+	dep := rec.RequireDependencyUnder(t, info.SpanId)
+	if dep.Id != "span" ||
+		dep.Type != "SQL" ||
+		dep.Target != "orders-db" ||
+		dep.Name != "SELECT orders" ||
+		dep.ResultCode != "1205" ||
+		dep.Data != "SELECT * FROM orders" ||
+		dep.Success ||
+		dep.OperationId != info.TraceId {
+		t.Errorf("unexpected dependency %+v", dep)
+	}
+}
+
+func TestTraceLogInfo(t *testing.T) {
+	core, rec := appinsightstest.NewCore("info-test")
+	ctx, info := newTracedContext()
+	logged := time.Now().Add(-time.Hour)
+
+	before := time.Now()
+	core.TraceLogInfo(ctx, ait.LogInfo{Message: "defaults"})
+	after := time.Now()
+	core.TraceLogInfo(ctx, ait.LogInfo{
+		Message:       "explicit",
+		SeverityLevel: ait.Warning,
+		Timestamp:     logged,
+	})
+
+	defaults := rec.RequireTrace(t, "defaults")
+	if defaults.SeverityLevel != ait.Verbose {
+		t.Errorf("severity %v, expected Verbose", defaults.SeverityLevel)
+	}
+	if defaults.Timestamp.Before(before) || defaults.Timestamp.After(after) {
+		t.Errorf("timestamp %v, expected now", defaults.Timestamp)
+	}
+	if defaults.OperationId != info.TraceId || defaults.ParentId != info.SpanId {
+		t.Errorf("ids not extracted from the context %+v", defaults.TelemetryBase)
+	}
+	explicit := rec.RequireTrace(t, "explicit")
+	if explicit.SeverityLevel != ait.Warning || !explicit.Timestamp.Equal(logged) {
+		t.Errorf("unexpected trace %+v", explicit)
+	}
+}
+
+// Traces the error through a helper skipping its own frame
+func traceSkippingHelper(core *ait.AppInsightsCore, err error) {
+	core.TraceExceptionInfo(context.Background(), ait.ExceptionInfo{
+		Error: err,
+		Skip:  1,
+	})
+}
+
+func TestTraceExceptionInfo(t *testing.T) {
+	core, rec := appinsightstest.NewCore("info-test")
+	ctx, info := newTracedContext()
+	critical := ait.Critical
+	occurred := time.Now().Add(-time.Minute)
+
+	before := time.Now()
+	core.TraceExceptionInfo(ctx, ait.ExceptionInfo{Error: errors.New("defaults")})
+	after := time.Now()
+	core.TraceExceptionInfo(ctx, ait.ExceptionInfo{
+		Error:         "explicit",
+		SeverityLevel: &critical,
+		ProblemId:     "checkout-failed",
+		Timestamp:     occurred,
+	})
+	traceSkippingHelper(core, errors.New("skipped"))
+
+	for _, ex := range rec.Exceptions() {
+		switch appinsightstest.ItemName(ex) {
+		case "defaults":
+			if ex.SeverityLevel != ait.Error || ex.ProblemId != "" {
+				t.Errorf("unexpected exception %+v", ex)
+			}
+			if ex.Timestamp.Before(before) || ex.Timestamp.After(after) {
+				t.Errorf("timestamp %v, expected now", ex.Timestamp)
+			}
+			if ex.OperationId != info.TraceId || ex.ParentId != info.SpanId {
+				t.Errorf("ids not extracted from the context %+v", ex.TelemetryBase)
+			}
+			if !strings.HasSuffix(ex.Frames[0].Method, "TestTraceExceptionInfo") {
+				t.Errorf("expected the caller on top, got %+v", ex.Frames[0])
+			}
+		case "explicit":
+			if ex.SeverityLevel != ait.Critical ||
+				ex.ProblemId != "checkout-failed" ||
+				!ex.Timestamp.Equal(occurred) {
+				t.Errorf("unexpected exception %+v", ex)
+			}
+		case "skipped":
+			if !strings.HasSuffix(ex.Frames[0].Method, "TestTraceExceptionInfo") {
+				t.Errorf("expected the caller of the helper on top, got %+v", ex.Frames[0])
+			}
+		default:
+			t.Errorf("unexpected exception %s", appinsightstest.ItemName(ex))
+		}
+	}
+	rec.RequireCount(t, 3)
+}
+
+func TestInfoMeasurements(t *testing.T) {
+	client := &capturingClient{}
+	rec := appinsightstest.NewRecorder()
+	core := ait.NewAppInsightsCoreWithExporter(
+		"info-test",
+		ait.NewMultiExporter(rec, ait.NewAppInsightsExporter(client)),
+		nil,
+	)
+	ctx, _ := newTracedContext()
+	measurements := map[string]float64{"rows": 3, "bytes": 512}
+
+	core.TraceRequestInfo(ctx, ait.RequestInfo{
+		Name:         "GET /orders",
+		StatusCode:   200,
+		Measurements: measurements,
+	})
+	core.TracePageViewInfo(ctx, ait.PageViewInfo{
+		Name:         "Home",
+		Measurements: measurements,
+	})
+	core.TraceDependencyInfo(ctx, ait.DependencyInfo{
+		Type:         "postgres",
+		Name:         "SELECT orders",
+		Success:      true,
+		Measurements: measurements,
+	})
+	core.TraceExceptionInfo(ctx, ait.ExceptionInfo{
+		Error:        errors.New("failed"),
+		Measurements: measurements,
+	})
+	core.TraceLogInfo(ctx, ait.LogInfo{
+		Message:      "loaded",
+		Fields:       map[string]string{"source": "cache"},
+		Measurements: measurements,
+	})
+	_, span := core.StartSpan(ctx, "LoadOrders", ait.SpanKindInternal)
+	span.SetMeasurement("cacheHitRatio", 0.5)
+	span.End()
+
+	expectMeasurements := func(name string, got map[string]float64) {
+		t.Helper()
+		if len(got) != len(measurements) ||
+			got["rows"] != 3 ||
+			got["bytes"] != 512 {
+			t.Errorf("%s measurements %v", name, got)
+		}
+	}
+	expectMeasurements("request", rec.RequireRequest(t, "GET /orders").Measurements)
+	expectMeasurements("page view", rec.PageViews()[0].Measurements)
+	expectMeasurements("dependency", rec.RequireDependency(t, "SELECT orders").Measurements)
+	expectMeasurements("exception", rec.RequireException(t).Measurements)
+
+	log := rec.RequireTrace(t, "loaded")
+	if log.Properties["rows"] != "3" ||
+		log.Properties["bytes"] != "512" ||
+		log.Properties["source"] != "cache" {
+		t.Errorf("log measurements not recorded as custom values %v", log.Properties)
+	}
+
+	spanDep := rec.RequireDependency(t, "LoadOrders")
+	if len(spanDep.Measurements) != 1 || spanDep.Measurements["cacheHitRatio"] != 0.5 {
+		t.Errorf("span measurements %v", spanDep.Measurements)
+	}
+
+	measurements["rows"] = 100
+	if rec.RequireRequest(t, "GET /orders").Measurements["rows"] != 3 {
+		t.Errorf("the telemetry shares the measurements of the caller")
+	}
+
+	exported := 0
+	for _, tele := range client.tracked {
+		var got map[string]float64
+		switch data := tele.TelemetryData().(type) {
+		case *contracts.RequestData:
+			got = data.Measurements
+		case *contracts.PageViewData:
+			got = data.Measurements
+		case *contracts.RemoteDependencyData:
+			if data.Name != "SELECT orders" {
+				continue
+			}
+			got = data.Measurements
+		case *contracts.ExceptionData:
+			got = data.Measurements
+		default:
+			continue
+		}
+		exported++
+		if got["rows"] != 3 || got["bytes"] != 512 {
+			t.Errorf("exported %T measurements %v", tele, got)
+		}
+	}
+	if exported != 4 {
+		t.Errorf("expected 4 exported items with measurements, got %d", exported)
+	}
+}
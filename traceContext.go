@@ -0,0 +1,437 @@
+package appinsightstrace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Name of the header carrying the w3c traceparent
+	TraceParentHeader = "traceparent"
+	// Name of the header carrying the w3c tracestate
+	TraceStateHeader = "tracestate"
+
+	// The traceparent version supported (and generated) by this package
+	TraceParentVersion = "00"
+	// Trace flags with the sampled bit set
+	TraceFlagsSampled = "01"
+	// Trace flags with the sampled bit unset
+	TraceFlagsNotSampled = "00"
+
+	// Maximum number of list-members allowed in a tracestate
+	MaxTraceStateMembers = 32
+
+	traceParentLength = 55
+	traceIdLength     = 32
+	spanIdLength      = 16
+)
+
+var (
+	ErrInvalidTraceParent = errors.New("invalid traceparent")
+	ErrInvalidTraceState  = errors.New("invalid tracestate")
+)
+
+// Parsed w3c trace-context (https://www.w3.org/TR/trace-context/) as it is
+// carried by the traceparent and tracestate headers
+//
+// Version: version of the traceparent (ex: 00)
+// TraceId: 32 lowercase hex characters identifying the whole trace
+// ParentId: 16 lowercase hex characters identifying the span of the caller
+// Flags: 2 lowercase hex characters (ex: 01 if sampled)
+// State: vendor specific trace information carried by the tracestate header
+type TraceContext struct {
+	Version  string
+	TraceId  string
+	ParentId string
+	Flags    string
+	State    TraceState
+}
+
+// Constructs a new TraceContext for the root of a trace with a random trace
+// id and parent id, marked as sampled
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		Version:  TraceParentVersion,
+		TraceId:  NewTraceId(),
+		ParentId: NewSpanId(),
+		Flags:    TraceFlagsSampled,
+	}
+}
+
+// Parses the traceparent and tracestate header values, an error is returned
+// only if the traceparent is invalid, an invalid tracestate is discarded as
+// required by the spec
+func ParseTraceContext(
+	traceparent string,
+	tracestate string,
+) (TraceContext, error) {
+	tc, err := ParseTraceParent(traceparent)
+	if err != nil {
+		return TraceContext{}, err
+	}
+	if state, err := ParseTraceState(tracestate); err == nil {
+		tc.State = state
+	}
+	return tc, nil
+}
+
+// Parses a traceparent header value, higher versions than the one supported
+// are parsed according to the forward compatibility rules of the spec
+// (https://www.w3.org/TR/trace-context/#versioning-of-traceparent)
+func ParseTraceParent(traceparent string) (TraceContext, error) {
+	traceparent = strings.TrimSpace(traceparent)
+	if len(traceparent) < traceParentLength {
+		return TraceContext{}, fmt.Errorf(
+			"%w: expected at least %d characters",
+			ErrInvalidTraceParent,
+			traceParentLength,
+		)
+	}
+
+	version := traceparent[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return TraceContext{}, fmt.Errorf(
+			"%w: invalid version %q",
+			ErrInvalidTraceParent,
+			version,
+		)
+	}
+	if version == TraceParentVersion {
+		if len(traceparent) != traceParentLength {
+			return TraceContext{}, fmt.Errorf(
+				"%w: expected %d characters for version %s",
+				ErrInvalidTraceParent,
+				traceParentLength,
+				version,
+			)
+		}
+	} else if len(traceparent) > traceParentLength &&
+		traceparent[traceParentLength] != '-' {
+		return TraceContext{}, fmt.Errorf(
+			"%w: unexpected character after trace-flags",
+			ErrInvalidTraceParent,
+		)
+	}
+
+	if traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return TraceContext{}, fmt.Errorf(
+			"%w: malformed delimiters",
+			ErrInvalidTraceParent,
+		)
+	}
+
+	tc := TraceContext{
+		Version:  version,
+		TraceId:  traceparent[3:35],
+		ParentId: traceparent[36:52],
+		Flags:    traceparent[53:55],
+	}
+	if !IsValidTraceId(tc.TraceId) {
+		return TraceContext{}, fmt.Errorf(
+			"%w: invalid trace-id %q",
+			ErrInvalidTraceParent,
+			tc.TraceId,
+		)
+	}
+	if !IsValidSpanId(tc.ParentId) {
+		return TraceContext{}, fmt.Errorf(
+			"%w: invalid parent-id %q",
+			ErrInvalidTraceParent,
+			tc.ParentId,
+		)
+	}
+	if !isLowerHex(tc.Flags) {
+		return TraceContext{}, fmt.Errorf(
+			"%w: invalid trace-flags %q",
+			ErrInvalidTraceParent,
+			tc.Flags,
+		)
+	}
+	return tc, nil
+}
+
+// Serializes the trace context into a traceparent header value, the supported
+// version is always used as the spec requires since fields of unknown higher
+// versions can not be propagated
+func (tc TraceContext) TraceParent() string {
+	flags := tc.Flags
+	if flags == "" {
+		flags = TraceFlagsNotSampled
+	}
+	bldr := strings.Builder{}
+	bldr.Grow(traceParentLength)
+	bldr.WriteString(TraceParentVersion)
+	bldr.WriteByte('-')
+	bldr.WriteString(tc.TraceId)
+	bldr.WriteByte('-')
+	bldr.WriteString(tc.ParentId)
+	bldr.WriteByte('-')
+	bldr.WriteString(flags)
+	return bldr.String()
+}
+
+// Serializes the trace state into a tracestate header value
+func (tc TraceContext) TraceState() string {
+	return tc.State.String()
+}
+
+// Whether the trace and parent ids are valid
+func (tc TraceContext) IsValid() bool {
+	return IsValidTraceId(tc.TraceId) && IsValidSpanId(tc.ParentId)
+}
+
+// Whether the sampled bit of the trace flags is set
+func (tc TraceContext) IsSampled() bool {
+	flags, err := hex.DecodeString(tc.Flags)
+	if err != nil || len(flags) != 1 {
+		return false
+	}
+	return flags[0]&0x01 == 0x01
+}
+
+// Single key value pair of a tracestate
+type TraceStateMember struct {
+	Key   string
+	Value string
+}
+
+// Ordered list of vendor specific trace information, the left most member is
+// the most recently updated
+type TraceState []TraceStateMember
+
+// Parses a tracestate header value, empty list-members are ignored and an
+// error is returned if any member is invalid, a key is duplicated or there
+// are more than MaxTraceStateMembers members
+func ParseTraceState(tracestate string) (TraceState, error) {
+	var state TraceState
+	if strings.TrimSpace(tracestate) == "" {
+		return state, nil
+	}
+	seen := map[string]struct{}{}
+	for _, raw := range strings.Split(tracestate, ",") {
+		member := strings.Trim(raw, " \t")
+		if member == "" {
+			continue
+		}
+		idx := strings.IndexByte(member, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf(
+				"%w: list-member %q is missing '='",
+				ErrInvalidTraceState,
+				member,
+			)
+		}
+		key, value := member[:idx], member[idx+1:]
+		if !isValidTraceStateKey(key) {
+			return nil, fmt.Errorf("%w: invalid key %q", ErrInvalidTraceState, key)
+		}
+		if !isValidTraceStateValue(value) {
+			return nil, fmt.Errorf(
+				"%w: invalid value for key %q",
+				ErrInvalidTraceState,
+				key,
+			)
+		}
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidTraceState, key)
+		}
+		seen[key] = struct{}{}
+		state = append(state, TraceStateMember{Key: key, Value: value})
+	}
+	if len(state) > MaxTraceStateMembers {
+		return nil, fmt.Errorf(
+			"%w: more than %d list-members",
+			ErrInvalidTraceState,
+			MaxTraceStateMembers,
+		)
+	}
+	return state, nil
+}
+
+// Serializes the trace state into a tracestate header value
+func (ts TraceState) String() string {
+	bldr := strings.Builder{}
+	for i, member := range ts {
+		if i > 0 {
+			bldr.WriteByte(',')
+		}
+		bldr.WriteString(member.Key)
+		bldr.WriteByte('=')
+		bldr.WriteString(member.Value)
+	}
+	return bldr.String()
+}
+
+// Returns the value for the key and whether it was found
+func (ts TraceState) Get(key string) (string, bool) {
+	for _, member := range ts {
+		if member.Key == key {
+			return member.Value, true
+		}
+	}
+	return "", false
+}
+
+// Returns a new trace state with the key set to the value and moved to the
+// beginning of the list, right most members are dropped should the state
+// exceed MaxTraceStateMembers
+func (ts TraceState) Set(key string, value string) (TraceState, error) {
+	if !isValidTraceStateKey(key) {
+		return ts, fmt.Errorf("%w: invalid key %q", ErrInvalidTraceState, key)
+	}
+	if !isValidTraceStateValue(value) {
+		return ts, fmt.Errorf(
+			"%w: invalid value for key %q",
+			ErrInvalidTraceState,
+			key,
+		)
+	}
+	state := make(TraceState, 0, len(ts)+1)
+	state = append(state, TraceStateMember{Key: key, Value: value})
+	for _, member := range ts {
+		if member.Key != key {
+			state = append(state, member)
+		}
+	}
+	if len(state) > MaxTraceStateMembers {
+		state = state[:MaxTraceStateMembers]
+	}
+	return state, nil
+}
+
+// Returns a new trace state without the key
+func (ts TraceState) Delete(key string) TraceState {
+	state := make(TraceState, 0, len(ts))
+	for _, member := range ts {
+		if member.Key != key {
+			state = append(state, member)
+		}
+	}
+	return state
+}
+
+// Generates a new random, non zero, 16 byte trace id as lowercase hex
+func NewTraceId() string {
+	return newRandomId(traceIdLength / 2)
+}
+
+// Generates a new random, non zero, 8 byte span id as lowercase hex
+func NewSpanId() string {
+	return newRandomId(spanIdLength / 2)
+}
+
+// Whether the id is a valid w3c trace-id (32 lowercase hex, not all zeros)
+func IsValidTraceId(id string) bool {
+	return len(id) == traceIdLength && isLowerHex(id) && !isAllZeros(id)
+}
+
+// Whether the id is a valid w3c parent-id (16 lowercase hex, not all zeros)
+func IsValidSpanId(id string) bool {
+	return len(id) == spanIdLength && isLowerHex(id) && !isAllZeros(id)
+}
+
+func newRandomId(size int) string {
+	buf := make([]byte, size)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Errorf("failed to generate random id: %w", err))
+		}
+		for _, b := range buf {
+			if b != 0 {
+				return hex.EncodeToString(buf)
+			}
+		}
+	}
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLowerAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isTraceStateKeyChar(c byte) bool {
+	return isLowerAlpha(c) || isDigit(c) ||
+		c == '_' || c == '-' || c == '*' || c == '/'
+}
+
+// key = simple-key / multi-tenant-key
+// simple-key = lcalpha 0*255( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+// multi-tenant-key = tenant-id "@" system-id
+// tenant-id = ( lcalpha / DIGIT ) 0*240( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+// system-id = lcalpha 0*13( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+func isValidTraceStateKey(key string) bool {
+	if at := strings.IndexByte(key, '@'); at >= 0 {
+		tenant, system := key[:at], key[at+1:]
+		if len(tenant) == 0 || len(tenant) > 241 ||
+			len(system) == 0 || len(system) > 14 {
+			return false
+		}
+		if !isLowerAlpha(tenant[0]) && !isDigit(tenant[0]) {
+			return false
+		}
+		if !isLowerAlpha(system[0]) {
+			return false
+		}
+		for i := 1; i < len(tenant); i++ {
+			if !isTraceStateKeyChar(tenant[i]) {
+				return false
+			}
+		}
+		for i := 1; i < len(system); i++ {
+			if !isTraceStateKeyChar(system[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(key) == 0 || len(key) > 256 || !isLowerAlpha(key[0]) {
+		return false
+	}
+	for i := 1; i < len(key); i++ {
+		if !isTraceStateKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// value = 0*255(chr) nblk-chr
+// nblk-chr = %x21-2B / %x2D-3C / %x3E-7E
+// chr = %x20 / nblk-chr
+func isValidTraceStateValue(value string) bool {
+	if len(value) == 0 || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package appinsightstrace_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+)
+
+const (
+	testTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanId  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	valid := map[string]ait.TraceContext{
+		"00-" + testTraceId + "-" + testSpanId + "-01": {
+			Version: "00", TraceId: testTraceId, ParentId: testSpanId, Flags: "01",
+		},
+		" 00-" + testTraceId + "-" + testSpanId + "-00 ": {
+			Version: "00", TraceId: testTraceId, ParentId: testSpanId, Flags: "00",
+		},
+		// higher versions may append fields after a delimiter
+		"01-" + testTraceId + "-" + testSpanId + "-01": {
+			Version: "01", TraceId: testTraceId, ParentId: testSpanId, Flags: "01",
+		},
+		"cc-" + testTraceId + "-" + testSpanId + "-01-what-the-future-holds": {
+			Version: "cc", TraceId: testTraceId, ParentId: testSpanId, Flags: "01",
+		},
+	}
+	for header, expected := range valid {
+		tc, err := ait.ParseTraceParent(header)
+		if err != nil {
+			t.Errorf("ParseTraceParent(%q) unexpected error: %v", header, err)
+			continue
+		}
+		if fmt.Sprint(tc) != fmt.Sprint(expected) {
+			t.Errorf("ParseTraceParent(%q) = %+v, expected %+v", header, tc, expected)
+		}
+	}
+
+	invalid := map[string]string{
+		"empty":                    "",
+		"short":                    "00-" + testTraceId + "-" + testSpanId + "-0",
+		"version ff":               "ff-" + testTraceId + "-" + testSpanId + "-01",
+		"uppercase version":        "0A-" + testTraceId + "-" + testSpanId + "-01",
+		"suffix on version 00":     "00-" + testTraceId + "-" + testSpanId + "-01-extra",
+		"future suffix without -":  "01-" + testTraceId + "-" + testSpanId + "-01extra",
+		"zero trace id":            "00-" + strings.Repeat("0", 32) + "-" + testSpanId + "-01",
+		"zero parent id":           "00-" + testTraceId + "-" + strings.Repeat("0", 16) + "-01",
+		"uppercase trace id":       "00-" + strings.ToUpper(testTraceId) + "-" + testSpanId + "-01",
+		"uppercase parent id":      "00-" + testTraceId + "-" + strings.ToUpper(testSpanId) + "-01",
+		"uppercase flags":          "00-" + testTraceId + "-" + testSpanId + "-0A",
+		"non hex trace id":         "00-" + strings.Repeat("g", 32) + "-" + testSpanId + "-01",
+		"malformed delimiters":     "00_" + testTraceId + "_" + testSpanId + "_01",
+		"trace id of wrong length": "00-" + testTraceId + "0-" + testSpanId + "-01",
+	}
+	for name, header := range invalid {
+		if tc, err := ait.ParseTraceParent(header); !errors.Is(err, ait.ErrInvalidTraceParent) {
+			t.Errorf("%s: ParseTraceParent(%q) = %+v, %v, expected ErrInvalidTraceParent", name, header, tc, err)
+		}
+	}
+}
+
+func TestParseTraceState(t *testing.T) {
+	members := make([]string, ait.MaxTraceStateMembers)
+	for i := range members {
+		members[i] = fmt.Sprintf("k%d=v%d", i, i)
+	}
+	valid := map[string]string{
+		"":                  "",
+		"congo=t61rcWkgMzE": "congo=t61rcWkgMzE",
+		"rojo=00f067aa0ba902b7, congo=t61rcWkgMzE": "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE",
+		"a=1,,\tb=2 ,":             "a=1,b=2",
+		"tenant@system=value":      "tenant@system=value",
+		"0tenant@vendor=value":     "0tenant@vendor=value",
+		"key=value with spaces":    "key=value with spaces",
+		"key/_-*=value":            "key/_-*=value",
+		strings.Join(members, ","): strings.Join(members, ","),
+	}
+	for header, expected := range valid {
+		state, err := ait.ParseTraceState(header)
+		if err != nil {
+			t.Errorf("ParseTraceState(%q) unexpected error: %v", header, err)
+			continue
+		}
+		if state.String() != expected {
+			t.Errorf("ParseTraceState(%q) = %q, expected %q", header, state.String(), expected)
+		}
+	}
+
+	invalid := map[string]string{
+		"too many members":      strings.Join(append(members, "extra=1"), ","),
+		"duplicate key":         "a=1,b=2,a=3",
+		"missing =":             "congo",
+		"empty value":           "congo=",
+		"uppercase key":         "Congo=1",
+		"key starting by digit": "0congo=1",
+		"empty tenant":          "@system=1",
+		"empty system":          "tenant@=1",
+		"system starting digit": "tenant@0system=1",
+		"system too long":       "tenant@" + strings.Repeat("s", 15) + "=1",
+		"value with =":          "congo=a=b",
+		"value with control":    "congo=a\tb",
+		"value with non ascii":  "congo=café",
+		"key too long":          strings.Repeat("k", 257) + "=1",
+		"value too long":        "congo=" + strings.Repeat("v", 257),
+		"invalid among valid":   "a=1,B=2",
+	}
+	for name, header := range invalid {
+		if state, err := ait.ParseTraceState(header); !errors.Is(err, ait.ErrInvalidTraceState) {
+			t.Errorf("%s: ParseTraceState(%q) = %q, %v, expected ErrInvalidTraceState", name, header, state, err)
+		}
+	}
+}
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	traceparent := "00-" + testTraceId + "-" + testSpanId + "-01"
+	tracestate := "rojo=00f067aa0ba902b7,tenant@congo=t61rcWkgMzE"
+	tc, err := ait.ParseTraceContext(traceparent, tracestate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tc.TraceParent() != traceparent || tc.TraceState() != tracestate {
+		t.Errorf("round trip %q %q, expected %q %q", tc.TraceParent(), tc.TraceState(), traceparent, tracestate)
+	}
+	if !tc.IsValid() || !tc.IsSampled() {
+		t.Errorf("expected a valid and sampled context %+v", tc)
+	}
+
+	// higher versions are propagated as the supported version
+	future, err := ait.ParseTraceParent("cc-" + testTraceId + "-" + testSpanId + "-00-extra")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if future.TraceParent() != "00-"+testTraceId+"-"+testSpanId+"-00" || future.IsSampled() {
+		t.Errorf("unexpected traceparent %q", future.TraceParent())
+	}
+
+	// an invalid tracestate is discarded, an invalid traceparent is not
+	if tc, err := ait.ParseTraceContext(traceparent, "a=1,a=2"); err != nil || len(tc.State) != 0 {
+		t.Errorf("expected the tracestate to be discarded, got %+v, %v", tc, err)
+	}
+	if _, err := ait.ParseTraceContext("00-invalid", tracestate); !errors.Is(err, ait.ErrInvalidTraceParent) {
+		t.Errorf("expected ErrInvalidTraceParent, got %v", err)
+	}
+
+	generated := ait.NewTraceContext()
+	parsed, err := ait.ParseTraceParent(generated.TraceParent())
+	if err != nil || fmt.Sprint(parsed) != fmt.Sprint(generated) {
+		t.Errorf("generated %+v parsed as %+v, %v", generated, parsed, err)
+	}
+}
+
+func TestTraceStateSet(t *testing.T) {
+	state, _ := ait.ParseTraceState("a=1,b=2")
+	state, err := state.Set("b", "3")
+	if err != nil || state.String() != "b=3,a=1" {
+		t.Errorf("Set moved %q, %v, expected b=3,a=1", state.String(), err)
+	}
+	if _, err := state.Set("B", "1"); !errors.Is(err, ait.ErrInvalidTraceState) {
+		t.Errorf("expected ErrInvalidTraceState for an invalid key, got %v", err)
+	}
+	if val, ok := state.Delete("b").Get("b"); ok {
+		t.Errorf("deleted key found with %q", val)
+	}
+
+	full := ait.TraceState{}
+	for i := 0; i < ait.MaxTraceStateMembers; i++ {
+		full, _ = full.Set(fmt.Sprintf("k%d", i), "v")
+	}
+	full, _ = full.Set("latest", "v")
+	if len(full) != ait.MaxTraceStateMembers || full[0].Key != "latest" {
+		t.Errorf("expected the right most member dropped, got %q", full.String())
+	}
+	if _, ok := full.Get("k0"); ok {
+		t.Errorf("right most member k0 not dropped")
+	}
+}
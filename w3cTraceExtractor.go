@@ -0,0 +1,117 @@
+package appinsightstrace
+
+import "context"
+
+// W3C trace-context information of the current operation as it is stored in
+// the context (https://www.w3.org/TR/trace-context/)
+//
+// Version: version of the traceparent format (ex: 00)
+// TraceId: the common identifier for all operations within the trace
+// ParentId: the span id of the direct dependent (caller) of this operation,
+//
+//	left empty if this operation is the root of the trace
+//
+// SpanId: the unique id of the current operation (request id)
+// Flags: the trace flags (ex: 01 if sampled)
+// State: the tracestate received with the operation, to be propagated to any
+//
+//	outgoing calls
+type TraceInfo struct {
+	Version  string
+	TraceId  string
+	ParentId string
+	SpanId   string
+	Flags    string
+	State    string
+}
+
+type traceInfoKey struct{}
+
+// Returns a copy of the context carrying the provided trace information, this
+// is meant to be used by middlewares at the start of an operation so the
+// W3CTraceExtractor is able to link any telemetry traced with the context
+func ContextWithTraceInfo(
+	ctx context.Context,
+	info TraceInfo,
+) context.Context {
+	return context.WithValue(ctx, traceInfoKey{}, info)
+}
+
+// Reads back the trace information injected using ContextWithTraceInfo, ok
+// will be false if the context does not carry any trace information
+func TraceInfoFromContext(
+	ctx context.Context,
+) (info TraceInfo, ok bool) {
+	if ctx == nil {
+		return TraceInfo{}, false
+	}
+	info, ok = ctx.Value(traceInfoKey{}).(TraceInfo)
+	return info, ok
+}
+
+// Implementation of ITraceExtractor that extracts the trace information
+// injected into the context with ContextWithTraceInfo, if the context does not
+// carry any trace information empty strings are returned
+type W3CTraceExtractor struct{}
+
+var _ ITraceExtractor = (*W3CTraceExtractor)(nil)
+
+func (*W3CTraceExtractor) ExtractTraceInfo(
+	ctx context.Context,
+) (ver, tid, pid, rid, flg string) {
+	info, ok := TraceInfoFromContext(ctx)
+	if !ok {
+		return "", "", "", "", ""
+	}
+	return info.Version, info.TraceId, info.ParentId, info.SpanId, info.Flags
+}
+
+// Builds the TraceInfo for a new operation (with a newly generated span id)
+// continuing the trace described by the traceparent and tracestate values
+// received with the operation, a new trace is started if the traceparent is
+// missing or invalid
+func IncomingTraceInfo(
+	traceparent string,
+	tracestate string,
+) TraceInfo {
+	tc, err := ParseTraceContext(traceparent, tracestate)
+	if err != nil {
+		return TraceInfo{
+			Version: TraceParentVersion,
+			TraceId: NewTraceId(),
+			SpanId:  NewSpanId(),
+			Flags:   TraceFlagsSampled,
+		}
+	}
+	return TraceInfo{
+		Version:  TraceParentVersion,
+		TraceId:  tc.TraceId,
+		ParentId: tc.ParentId,
+		SpanId:   NewSpanId(),
+		Flags:    tc.Flags,
+		State:    tc.State.String(),
+	}
+}
+
+// Returns a copy of the context with the remote caller described by the
+// traceparent and tracestate values as the current span, spans started from
+// the returned context (ex: a consumer span for a received message) are
+// linked to the caller, the context is returned unchanged if the traceparent
+// is missing or invalid
+func ContextWithTraceParent(
+	ctx context.Context,
+	traceparent string,
+	tracestate string,
+) context.Context {
+	tc, err := ParseTraceContext(traceparent, tracestate)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithTraceInfo(ctx, TraceInfo{
+		Version: TraceParentVersion,
+		TraceId: tc.TraceId,
+		SpanId:  tc.ParentId,
+		Flags:   tc.Flags,
+		State:   tc.State.String(),
+	})
+}
@@ -0,0 +1,242 @@
+package appinsightstrace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/microsoft/ApplicationInsights-Go/appinsights/contracts"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Key of the zap field carrying the context, check ZapContext
+const ZapContextKey = "context"
+
+// Options for the zap core
+//
+// Level: the minimum level of entries to be traced, defaults to Debug
+// TraceExceptions: whether entries at Error level or above that carry an
+//
+//	error field (zap.Error) should also be traced as an exception telemetry
+type ZapCoreOptions struct {
+	Level           zapcore.LevelEnabler
+	TraceExceptions bool
+}
+
+// Returns a zap field carrying the context, the zap core will use the
+// ITraceExtractor of the AppInsightsCore to correlate the log with the
+// operation of the context, the field is skipped by any other encoder
+//
+//	lgr.Info("processing", appinsightstrace.ZapContext(ctx))
+func ZapContext(ctx context.Context) zap.Field {
+	return zap.Field{
+		Key:       ZapContextKey,
+		Type:      zapcore.SkipType,
+		Interface: ctx,
+	}
+}
+
+// Constructs a zapcore.Core that transmits every log entry as a trace log
+// telemetry, the fields of the entry are transmitted as custom values and the
+// trace information is extracted from the context field (check ZapContext).
+// The core can be combined with other cores using zapcore.NewTee, the logger
+// using it should not be the one provided to the AppInsightsCore constructors
+// since the diagnostic messages would be traced in a loop
+func (ins *AppInsightsCore) NewZapCore(
+	optn *ZapCoreOptions,
+) zapcore.Core {
+	if optn == nil {
+		optn = &ZapCoreOptions{}
+	}
+	level := optn.Level
+	if level == nil {
+		level = zapcore.DebugLevel
+	}
+	return &zapCore{
+		LevelEnabler:    level,
+		ins:             ins,
+		traceExceptions: optn.TraceExceptions,
+		ctx:             context.Background(),
+	}
+}
+
+type zapCore struct {
+	zapcore.LevelEnabler
+	ins             *AppInsightsCore
+	traceExceptions bool
+	fields          []zapcore.Field
+	ctx             context.Context
+}
+
+var _ zapcore.Core = (*zapCore)(nil)
+
+func (c *zapCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &zapCore{
+		LevelEnabler:    c.LevelEnabler,
+		ins:             c.ins,
+		traceExceptions: c.traceExceptions,
+		fields:          make([]zapcore.Field, len(c.fields), len(c.fields)+len(fields)),
+		ctx:             c.ctx,
+	}
+	copy(clone.fields, c.fields)
+	for _, field := range fields {
+		if ctx, ok := zapFieldContext(field); ok {
+			clone.ctx = ctx
+			continue
+		}
+		clone.fields = append(clone.fields, field)
+	}
+	return clone
+}
+
+func (c *zapCore) Check(
+	ent zapcore.Entry,
+	ce *zapcore.CheckedEntry,
+) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *zapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ctx := c.ctx
+	var errField error
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+		if err, ok := zapFieldError(field); ok {
+			errField = err
+		}
+	}
+	for _, field := range fields {
+		if fctx, ok := zapFieldContext(field); ok {
+			ctx = fctx
+			continue
+		}
+		field.AddTo(enc)
+		if err, ok := zapFieldError(field); ok {
+			errField = err
+		}
+	}
+
+	props := make(map[string]string, len(enc.Fields)+3)
+	for key, val := range enc.Fields {
+		props[key] = zapValueString(val)
+	}
+	if ent.LoggerName != "" {
+		props["logger"] = ent.LoggerName
+	}
+	if ent.Caller.Defined {
+		props["caller"] = ent.Caller.TrimmedPath()
+	}
+	if ent.Stack != "" {
+		props["stacktrace"] = ent.Stack
+	}
+
+	_, tid, _, rid, _ := c.ins.traceExtractor.ExtractTraceInfo(ctx)
+	c.ins.TraceLogWithIds(
+		tid,
+		rid,
+		ent.Message,
+		contracts.SeverityLevel(zapSeverityLevel(ent.Level)),
+		ent.Time,
+		props,
+	)
+
+	if c.traceExceptions && errField != nil && ent.Level >= zapcore.ErrorLevel {
+		exProps := make(map[string]string, len(props)+1)
+		for key, val := range props {
+			exProps[key] = val
+		}
+		exProps["message"] = ent.Message
+		severityLevel := zapSeverityLevel(ent.Level)
+		c.ins.traceExceptionInfo(ExceptionInfo{
+			TraceId:       tid,
+			ParentId:      rid,
+			Error:         errField,
+			Skip:          zapCallerSkip(),
+			SeverityLevel: &severityLevel,
+			Timestamp:     ent.Time,
+			Fields:        exProps,
+		}, nil)
+	}
+	return nil
+}
+
+// Gets the number of zap frames between the caller of zapCallerSkip (the
+// Write of the core) and the code calling the logger, so the exceptions are
+// not all grouped under the frames of zap
+func zapCallerSkip() int {
+	pcs := make([]uintptr, 32)
+	// skips runtime.Callers, zapCallerSkip and the Write of the core
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	skip := 0
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "go.uber.org/zap") {
+			return skip
+		}
+		skip++
+		if !more {
+			return 0
+		}
+	}
+}
+
+func (c *zapCore) Sync() error {
+	c.ins.Flush()
+	return nil
+}
+
+// Maps the zap level to the matching SeverityLevel, levels above Error
+// (DPanic, Panic and Fatal) are mapped to Critical
+func zapSeverityLevel(level zapcore.Level) SeverityLevel {
+	switch {
+	case level < zapcore.InfoLevel:
+		return Verbose
+	case level == zapcore.InfoLevel:
+		return Information
+	case level == zapcore.WarnLevel:
+		return Warning
+	case level == zapcore.ErrorLevel:
+		return Error
+	default:
+		return Critical
+	}
+}
+
+func zapFieldContext(field zapcore.Field) (context.Context, bool) {
+	if field.Key != ZapContextKey || field.Type != zapcore.SkipType {
+		return nil, false
+	}
+	ctx, ok := field.Interface.(context.Context)
+	return ctx, ok && ctx != nil
+}
+
+func zapFieldError(field zapcore.Field) (error, bool) {
+	if field.Key != "error" || field.Type != zapcore.ErrorType {
+		return nil, false
+	}
+	err, ok := field.Interface.(error)
+	return err, ok && err != nil
+}
+
+func zapValueString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	case error:
+		return v.Error()
+	case map[string]interface{}, []interface{}:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(val)
+}
@@ -0,0 +1,34 @@
+package appinsightstrace_test
+
+import (
+	"errors"
+	"testing"
+
+	ait "github.com/BetaLixT/appInsightsTrace"
+	"github.com/BetaLixT/appInsightsTrace/appinsightstest"
+	"go.uber.org/zap"
+)
+
+func TestZapCoreExceptionFrames(t *testing.T) {
+	core, rec := appinsightstest.NewCore("zap-test")
+	lgr := zap.New(core.NewZapCore(&ait.ZapCoreOptions{TraceExceptions: true}))
+
+	lgr.Error("failed", zap.Error(errors.New("boom")))
+	lgr.Sugar().Errorw("failed", "error", errors.New("boom"))
+
+	exceptions := rec.Exceptions()
+	if len(exceptions) != 2 {
+		t.Fatalf("expected 2 exceptions, got %d", len(exceptions))
+	}
+	for _, ex := range exceptions {
+		if len(ex.Frames) == 0 {
+			t.Fatal("exception has no frames")
+		}
+		if top := ex.Frames[0]; top.Method != "TestZapCoreExceptionFrames" {
+			t.Errorf("top frame %s.%s, expected the logger call site", top.Assembly, top.Method)
+		}
+		if ex.SeverityLevel != ait.Error {
+			t.Errorf("severity level %v, expected Error", ex.SeverityLevel)
+		}
+	}
+}